@@ -0,0 +1,42 @@
+package main
+
+import "sync"
+
+// chatLocks let the prompts of each chat reach its conversation one at a time, in the order they were sent,
+// while other chats carry on. The zero value is ready to use.
+type chatLocks struct {
+	mu    sync.Mutex
+	chats map[int64]*chatLock
+}
+
+type chatLock struct {
+	sync.Mutex
+	// waiting is how many are holding or waiting for the lock, so it's dropped once nobody is
+	waiting int
+}
+
+// lock waits until nothing else is being sent to the chat, and returns the function that lets the next prompt through
+func (l *chatLocks) lock(chatID int64) (unlock func()) {
+	l.mu.Lock()
+	if l.chats == nil {
+		l.chats = make(map[int64]*chatLock)
+	}
+	chat, ok := l.chats[chatID]
+	if !ok {
+		chat = &chatLock{}
+		l.chats[chatID] = chat
+	}
+	chat.waiting++
+	l.mu.Unlock()
+
+	chat.Lock()
+	return func() {
+		chat.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if chat.waiting--; chat.waiting == 0 {
+			delete(l.chats, chatID)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/chatgpt"
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/m1guelpf/chatgpt-telegram/src/session"
+	"github.com/m1guelpf/chatgpt-telegram/src/tgbot"
+	"github.com/spf13/pflag"
+)
+
+func loginCommand(flags *pflag.FlagSet) func(opts config.LoadOptions) error {
+	account := flags.String("account", config.DefaultAccount, "account to save the session to")
+
+	return func(opts config.LoadOptions) error {
+		a, err := newApp(opts)
+		if err != nil {
+			return err
+		}
+
+		token, err := session.GetSession()
+		if err != nil {
+			return errors.New(fmt.Sprintf("Couldn't log in: %v", err))
+		}
+		if err := a.persistentConfig.SetAccountSessionToken(*account, token); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't save OpenAI session: %v", err))
+		}
+
+		logger.Infof("Logged in, the session of account %s was saved", *account)
+		return nil
+	}
+}
+
+func logoutCommand(flags *pflag.FlagSet) func(opts config.LoadOptions) error {
+	account := flags.String("account", config.DefaultAccount, "account to forget the session of")
+
+	return func(opts config.LoadOptions) error {
+		a, err := newApp(opts)
+		if err != nil {
+			return err
+		}
+
+		if *account != config.DefaultAccount && !hasAccount(a.settings, *account) {
+			return errors.New(fmt.Sprintf("Unknown account %s", *account))
+		}
+		if err := a.persistentConfig.SetAccountSessionToken(*account, ""); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't remove OpenAI session: %v", err))
+		}
+		if err := session.ClearBrowserData(); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't remove browser data: %v", err))
+		}
+
+		logger.Infof("Logged out of account %s", *account)
+		if token, _ := configuredSession(a.settings); *account == config.DefaultAccount && token != "" {
+			logger.Warnf("A session is still configured through OPENAI_SESSION, OPENAI_SESSION_FILE or OPENAI_COOKIES_FILE, it will be used the next time the bot starts")
+		}
+		return nil
+	}
+}
+
+func checkCommand(flags *pflag.FlagSet) func(opts config.LoadOptions) error {
+	return func(opts config.LoadOptions) error {
+		a, err := newApp(opts)
+		if err != nil {
+			return err
+		}
+
+		failed := false
+		report := func(name string, err error) {
+			if err != nil {
+				failed = true
+				fmt.Printf("✗ %s: %v\n", name, err)
+			} else {
+				fmt.Printf("✓ %s\n", name)
+			}
+		}
+
+		report("Config", a.settings.ValidateWithDefaults())
+
+		bot, err := tgbot.New(a.settings.TelegramToken, a.settings.TelegramAPIEndpoint, 0)
+		if err == nil {
+			report(fmt.Sprintf("Telegram (@%s)", bot.Username), nil)
+		} else {
+			report("Telegram", err)
+		}
+
+		chatGPT, err := newChatGPT(a.settings, a.persistentConfig)
+		if err != nil {
+			report("OpenAI session", err)
+		}
+		for _, account := range chatGPT.Accounts {
+			report(fmt.Sprintf("Account %s", account.Name), chatGPT.CheckAccount(context.Background(), account.Name))
+		}
+
+		if failed {
+			return errors.New("Some checks failed")
+		}
+		return nil
+	}
+}
+
+func exportHistoryCommand(flags *pflag.FlagSet) func(opts config.LoadOptions) error {
+	accounts := flags.StringSlice("account", nil, "accounts to export (all website accounts by default)")
+	output := flags.StringP("output", "o", "", "file to write to (standard output by default)")
+
+	return func(opts config.LoadOptions) error {
+		a, err := newApp(opts)
+		if err != nil {
+			return err
+		}
+
+		chatGPT, err := newChatGPT(a.settings, a.persistentConfig)
+		if err != nil {
+			return err
+		}
+		if len(*accounts) == 0 {
+			for _, account := range chatGPT.Accounts {
+				if !account.IsAPI() {
+					*accounts = append(*accounts, account.Name)
+				}
+			}
+		}
+
+		var w io.Writer = os.Stdout
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Couldn't create output file: %v", err))
+			}
+			defer f.Close()
+			w = f
+		}
+
+		encoder := json.NewEncoder(w)
+		for _, account := range *accounts {
+			count := 0
+			err := chatGPT.ExportHistory(context.Background(), account, func(convo chatgpt.ExportedConversation) error {
+				count++
+				return encoder.Encode(convo)
+			})
+			if err != nil {
+				return errors.New(fmt.Sprintf("Couldn't export account %s: %v", account, err))
+			}
+			logger.Infof("Exported %d conversations of account %s", count, account)
+		}
+
+		return nil
+	}
+}
+
+// rotateKeyCommand re-encrypts the persistent config with the key in NEW_CONFIG_ENCRYPTION_KEY,
+// or with a newly generated one (which is printed) if it's not set.
+func rotateKeyCommand(flags *pflag.FlagSet) func(opts config.LoadOptions) error {
+	return func(opts config.LoadOptions) error {
+		a, err := newApp(opts)
+		if err != nil {
+			return err
+		}
+
+		newKey := a.settings.NewConfigEncryptionKey
+		generated := newKey == ""
+		if generated {
+			if newKey, err = config.GenerateEncryptionKey(); err != nil {
+				return errors.New(fmt.Sprintf("Couldn't generate key: %v", err))
+			}
+		}
+
+		key, err := config.LoadEncryptionKey(newKey, "")
+		if err != nil {
+			return err
+		}
+		if err := a.persistentConfig.RotateKey(key); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't rotate encryption key: %v", err))
+		}
+
+		if generated {
+			fmt.Printf("Your new encryption key is: %s\n", newKey)
+		}
+		logger.Infof("Secrets were re-encrypted. Update CONFIG_ENCRYPTION_KEY (or CONFIG_ENCRYPTION_KEY_FILE) with the new key before starting the bot again.")
+		return nil
+	}
+}
+
+func versionCommand(flags *pflag.FlagSet) func(opts config.LoadOptions) error {
+	return func(opts config.LoadOptions) error {
+		revision := ""
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					revision = " " + setting.Value
+				}
+			}
+		}
+
+		fmt.Printf("chatgpt-telegram %s%s (%s)\n", version, revision, runtime.Version())
+		return nil
+	}
+}
+
+func hasAccount(settings *config.Settings, name string) bool {
+	for _, account := range settings.Accounts {
+		if account.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newChatGPT sets up the accounts in the settings, including the session of the default account picked
+// like the bot does on start (see savedSession), without saving it or logging in with a browser.
+func newChatGPT(settings *config.Settings, persistentConfig *config.Config) (*chatgpt.ChatGPT, error) {
+	accounts := append([]config.Account(nil), settings.Accounts...)
+
+	token, err := savedSession(settings, persistentConfig)
+	if token != "" {
+		found := false
+		for i := range accounts {
+			if accounts[i].Name == config.DefaultAccount {
+				accounts[i].SessionToken, found = token, true
+			}
+		}
+		if !found {
+			accounts = append([]config.Account{{Name: config.DefaultAccount, SessionToken: token}}, accounts...)
+		}
+	}
+
+	chatGPT := chatgpt.Init(accounts)
+	chatGPT.SetModels(settings.Model, settings.APIModel)
+	return chatGPT, err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/documents"
+	"github.com/m1guelpf/chatgpt-telegram/src/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// documentExpiry is how long a file waits for the question about it
+const documentExpiry = time.Hour
+
+// maxSummaryParts is the most pieces a long file is split into to summarize it
+const maxSummaryParts = 10
+
+// summaryTimeout bounds summarizing all the parts of a long file
+const summaryTimeout = 5 * time.Minute
+
+// pendingDocument is the text of a file, waiting to be sent with the next prompt of the chat
+type pendingDocument struct {
+	name string
+	text string
+	// summarized is set if text is a summary of a file that was too long
+	summarized bool
+}
+
+// readDocument extracts the text of the file in message, summarizing it if it's too long,
+// and keeps it to be sent with the next prompt of the chat.
+func (h *handler) readDocument(ctx context.Context, settings *config.Settings, message *tgbotapi.Message) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "document.read", trace.WithAttributes(
+		attribute.String("document.mime_type", message.Document.MimeType),
+		attribute.Int("document.size", message.Document.FileSize),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if message.Document.FileSize > settings.DocumentMaxSizeMB<<20 {
+		return errors.New(fmt.Sprintf("The file is too big, it can be up to %d MB", settings.DocumentMaxSizeMB))
+	}
+
+	h.bot.SendTyping(message.Chat.ID)
+	data, name, err := h.bot.DownloadFile(ctx, message.Document.FileID)
+	if err != nil {
+		return err
+	}
+	if message.Document.FileName != "" {
+		name = message.Document.FileName
+	}
+
+	text, err := documents.Extract(data, name)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		return errors.New("The file is empty")
+	}
+
+	doc := pendingDocument{name: name, text: text}
+	if len([]rune(text)) > settings.DocumentMaxCharacters {
+		if doc.text, err = h.summarize(ctx, settings, message, name, text); err != nil {
+			return err
+		}
+		doc.summarized = true
+	}
+	span.SetAttributes(attribute.Bool("document.summarized", doc.summarized))
+
+	h.documents.Set(message.Chat.ID, doc, documentExpiry)
+	return nil
+}
+
+// summarize asks ChatGPT to summarize every part of a file that's too long to send whole, sharing the room
+// the file could take between the summaries. Each part counts as a prompt towards the rate limit of the user,
+// the first one being the message with the file, and the rest are counted up front.
+func (h *handler) summarize(ctx context.Context, settings *config.Settings, message *tgbotapi.Message, name string, text string) (string, error) {
+	chatID, maxCharacters := message.Chat.ID, settings.DocumentMaxCharacters
+	parts := documents.Chunk(text, maxCharacters)
+	if len(parts) > maxSummaryParts {
+		return "", errors.New(fmt.Sprintf("The file is too long, it can be up to %d characters", maxSummaryParts*maxCharacters))
+	}
+	if limit := settings.RateLimitMessages; limit > 0 && len(parts)-1 > limit && !settings.HasAdminID(message.From.ID) {
+		return "", errors.New(fmt.Sprintf("The file is too long, summarizing it takes %d prompts but you can only send %d at a time", len(parts), limit))
+	}
+	if reply := h.rateLimitedN(settings, message.From.ID, len(parts)-1); reply != "" {
+		return "", errors.New(fmt.Sprintf("The file is too long to summarize right now. %s", reply))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	h.bot.Send(chatID, 0, fmt.Sprintf("📄 %s is too long to send whole, summarizing it in %d parts first...", name, len(parts)))
+
+	// the summaries, with the blank lines between them, can't take more room than the file could
+	length := (maxCharacters - 2*(len(parts)-1)) / len(parts)
+	summaries := make([]string, 0, len(parts))
+	for i, part := range parts {
+		h.bot.SendTyping(chatID)
+		summary, err := h.chatGPT.Ask(ctx, "summary", fmt.Sprintf("This is part %d of %d of the file %s. Summarize it in at most %d characters, keeping any details needed to answer questions about it later:\n\n```\n%s\n```", i+1, len(parts), name, length, part))
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Couldn't summarize the file: %v", err))
+		}
+		summaries = append(summaries, summary)
+	}
+
+	summary := documents.Chunk(strings.Join(summaries, "\n\n"), maxCharacters)
+	if len(summary) > 1 {
+		h.bot.Send(chatID, 0, fmt.Sprintf("📄 The summary of %s came out too long, so its end was cut off.", name))
+	}
+	return summary[0], nil
+}
+
+// withDocument prepends the file waiting in the chat to the prompt, if there's one
+func (h *handler) withDocument(chatID int64, prompt string) string {
+	doc, ok := h.documents.Get(chatID)
+	if !ok {
+		return prompt
+	}
+	h.documents.Delete(chatID)
+
+	intro := fmt.Sprintf("Here's the file %s:", doc.name)
+	if doc.summarized {
+		intro = fmt.Sprintf("Here's a summary of the file %s, which was too long to send whole:", doc.name)
+	}
+	return fmt.Sprintf("%s\n\n```\n%s\n```\n\n%s", intro, doc.text, prompt)
+}
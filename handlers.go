@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/m1guelpf/chatgpt-telegram/src/chatgpt"
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/expirymap"
+	"github.com/m1guelpf/chatgpt-telegram/src/imagegen"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/m1guelpf/chatgpt-telegram/src/markdown"
+	"github.com/m1guelpf/chatgpt-telegram/src/metrics"
+	"github.com/m1guelpf/chatgpt-telegram/src/ratelimit"
+	"github.com/m1guelpf/chatgpt-telegram/src/speech"
+	"github.com/m1guelpf/chatgpt-telegram/src/tgbot"
+	"github.com/m1guelpf/chatgpt-telegram/src/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// speechTimeout bounds reading an answer out loud, including running the speech command
+const speechTimeout = 2 * time.Minute
+
+// handler answers the updates received by the bot
+type handler struct {
+	bot              *tgbot.Bot
+	chatGPT          *chatgpt.ChatGPT
+	persistentConfig *config.Config
+	limiter          *ratelimit.Limiter
+	// transcriber is nil if voice messages aren't supported
+	transcriber speech.Transcriber
+	// synthesizer is nil if answers can't be read out loud
+	synthesizer speech.Synthesizer
+	// generator is nil if /image isn't available
+	generator imagegen.Generator
+	// documents are the files waiting for a question, by chat
+	documents *expirymap.ExpiryMap[int64, pendingDocument]
+	// inlineAnswers are the recent answers to inline queries, by question
+	inlineAnswers *expirymap.ExpiryMap[string, string]
+	// chats keep a file being read from being overtaken by the next prompt of the chat
+	chats chatLocks
+}
+
+func (h *handler) handleMessage(ctx context.Context, settings *config.Settings, message *tgbotapi.Message) {
+	var (
+		updateText      = message.Text
+		updateChatID    = message.Chat.ID
+		updateMessageID = message.MessageID
+		updateUserID    = message.From.ID
+	)
+	log := logger.With("chat_id", updateChatID, "user_id", updateUserID)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int64("telegram.chat_id", updateChatID),
+		attribute.Int("telegram.message_id", updateMessageID),
+		attribute.Int64("telegram.user_id", updateUserID),
+	)
+
+	if !settings.IsAllowed(updateUserID) {
+		log.Infof("User is not allowed to use this bot")
+		h.bot.Send(updateChatID, updateMessageID, "You are not authorized to use this bot.")
+		return
+	}
+
+	if !message.IsCommand() {
+		isVoice := message.Voice != nil || message.Audio != nil
+		isPhoto := len(message.Photo) > 0
+		isDocument := message.Document != nil
+		if updateText == "" {
+			updateText = message.Caption
+		}
+		if updateText == "" && !isVoice && !isPhoto && !isDocument {
+			h.bot.Send(updateChatID, updateMessageID, "Only text, voice messages, photos and files can be sent to ChatGPT.")
+			return
+		}
+
+		if reply := h.rateLimited(settings, updateUserID); reply != "" {
+			h.bot.Send(updateChatID, updateMessageID, reply)
+			return
+		}
+
+		if isVoice {
+			text, err := h.transcribe(ctx, message)
+			if err != nil {
+				log.Warnf("Couldn't transcribe voice message: %v", err)
+				h.bot.Send(updateChatID, updateMessageID, fmt.Sprintf("Error: %v", err))
+				return
+			}
+			// the caption usually says what to do with the recording, like "translate this"
+			if updateText != "" {
+				text = updateText + "\n\n" + text
+			}
+			updateText = text
+		}
+
+		// a prompt sent while a file is still being read waits for it, so the file goes along with it
+		unlock := h.chats.lock(updateChatID)
+		if isDocument {
+			// long files take a while to summarize, so they're read without holding up the updates of other chats
+			ctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+			go func() {
+				defer unlock()
+				if err := h.readDocument(ctx, settings, message); err != nil {
+					log.Warnf("Couldn't read file: %v", err)
+					h.bot.Send(updateChatID, updateMessageID, fmt.Sprintf("Error: %v", err))
+					return
+				}
+				// without a caption, the file waits for the next message
+				if updateText == "" {
+					h.bot.Send(updateChatID, updateMessageID, "📄 Got it! What do you want to know about this file?")
+					return
+				}
+				h.sendPrompt(ctx, settings, log, message, updateText)
+			}()
+			return
+		}
+
+		defer unlock()
+		h.sendPrompt(ctx, settings, log, message, updateText)
+		return
+	}
+
+	var text string
+	switch message.Command() {
+	case "help", "start":
+		text = "Send a message to start talking with ChatGPT. You can use /reload at any point to clear the conversation history and start from scratch (don't worry, it won't delete the Telegram messages). If an answer gets cut off, send /continue to get the rest. You can also send a file (like a log, source file or PDF) and ask questions about it."
+		if h.synthesizer != nil {
+			text += " Send /voice on to also get answers as voice messages."
+		}
+		if h.generator != nil {
+			text += " Send /image followed by a description to draw it."
+		}
+	case "reload":
+		h.chatGPT.ResetConversation(updateChatID)
+		h.documents.Delete(updateChatID)
+		text = "Started a new conversation. Enjoy!"
+	case "session":
+		if !settings.HasAdminID(updateUserID) {
+			text = "Only admins can change the OpenAI session."
+			break
+		}
+
+		// don't leave the token lying around in the chat history
+		h.bot.DeleteMessage(updateChatID, updateMessageID)
+		updateMessageID = 0
+
+		account, token := config.DefaultAccount, ""
+		switch args := strings.Fields(message.CommandArguments()); len(args) {
+		case 1:
+			token = args[0]
+		case 2:
+			account, token = args[0], args[1]
+		}
+
+		if token == "" {
+			text = "Usage: /session [account] <token>"
+		} else if err := h.chatGPT.SetSessionToken(ctx, account, token); err != nil {
+			text = fmt.Sprintf("Invalid session token: %v", err)
+		} else if err := h.persistentConfig.SetAccountSessionToken(account, token); err != nil {
+			text = fmt.Sprintf("Session token updated, but couldn't be saved: %v", err)
+		} else {
+			text = "Session token updated."
+		}
+	case "status":
+		if !settings.HasAdminID(updateUserID) {
+			text = "Only admins can see the account status."
+			break
+		}
+
+		text = formatPoolStatus(h.chatGPT.PoolStatus())
+	case "continue":
+		if text = h.rateLimited(settings, updateUserID); text != "" {
+			break
+		}
+
+		unlock := h.chats.lock(updateChatID)
+		defer unlock()
+		observePrompt(settings, updateUserID, updateChatID)
+		feed, err := h.chatGPT.Continue(ctx, updateChatID, "")
+		if err != nil {
+			text = fmt.Sprintf("Error: %v", err)
+			break
+		}
+
+		h.bot.SendTyping(updateChatID)
+		answer, err := h.bot.ContinueLiveOutput(ctx, updateChatID, updateMessageID, feed)
+		if err != nil {
+			log.Errorf("Couldn't send answer: %v", err)
+			return
+		}
+		h.speak(ctx, updateChatID, updateMessageID, answer)
+		return
+	case "image":
+		if h.generator == nil {
+			text = "Images can't be generated, since no image generation backend is set up."
+			break
+		}
+
+		prompt, size, count, err := parseImageArgs(message.CommandArguments(), settings.ImageSize, settings.ImageMaxCount)
+		if err != nil {
+			text = fmt.Sprintf("%v. Usage: /image [size=%s] [count=1] <description>", err, settings.ImageSize)
+			break
+		}
+		if text = h.rateLimited(settings, updateUserID); text != "" {
+			break
+		}
+
+		observePrompt(settings, updateUserID, updateChatID)
+		if err := h.generateImages(ctx, updateChatID, updateMessageID, prompt, size, count); err != nil {
+			log.Warnf("Couldn't generate image: %v", err)
+			text = fmt.Sprintf("Error: %v", err)
+			break
+		}
+		return
+	case "voice":
+		if h.synthesizer == nil {
+			text = "Voice replies aren't available, since no text-to-speech backend is set up."
+			break
+		}
+
+		switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+		case "on":
+			if err := h.persistentConfig.SetVoiceReplies(updateChatID, true); err != nil {
+				text = fmt.Sprintf("Couldn't save the setting: %v", err)
+			} else {
+				text = "Answers will also be sent as voice messages."
+			}
+		case "off":
+			if err := h.persistentConfig.SetVoiceReplies(updateChatID, false); err != nil {
+				text = fmt.Sprintf("Couldn't save the setting: %v", err)
+			} else {
+				text = "Answers will only be sent as text."
+			}
+		default:
+			state := "off"
+			if h.persistentConfig.HasVoiceReplies(updateChatID) {
+				state = "on"
+			}
+			text = fmt.Sprintf("Voice replies are %s in this chat. Usage: /voice on|off", state)
+		}
+	default:
+		text = "Unknown command. Send /help to see a list of commands."
+	}
+
+	if _, err := h.bot.Send(updateChatID, updateMessageID, text); err != nil {
+		log.Errorf("Error sending message: %v", err)
+	}
+}
+
+func (h *handler) handleCallback(ctx context.Context, settings *config.Settings, query *tgbotapi.CallbackQuery) {
+	if query.Message == nil {
+		h.bot.AnswerCallback(query.ID, "")
+		return
+	}
+
+	log := logger.With("chat_id", query.Message.Chat.ID, "user_id", query.From.ID)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int64("telegram.chat_id", query.Message.Chat.ID),
+		attribute.Int("telegram.message_id", query.Message.MessageID),
+		attribute.Int64("telegram.user_id", query.From.ID),
+	)
+
+	if !settings.IsAllowed(query.From.ID) {
+		log.Infof("User is not allowed to use this bot")
+		h.bot.AnswerCallback(query.ID, "You are not authorized to use this bot.")
+		return
+	}
+
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+
+	gptMessageID, ok := tgbot.ParseContinueData(query.Data)
+	if !ok {
+		h.bot.AnswerCallback(query.ID, "Unknown action.")
+		return
+	}
+
+	if reply := h.rateLimited(settings, query.From.ID); reply != "" {
+		h.bot.AnswerCallback(query.ID, reply)
+		return
+	}
+
+	unlock := h.chats.lock(chatID)
+	defer unlock()
+	observePrompt(settings, query.From.ID, chatID)
+	feed, err := h.chatGPT.Continue(ctx, chatID, gptMessageID)
+	if err != nil {
+		h.bot.AnswerCallback(query.ID, err.Error())
+		h.bot.RemoveKeyboard(chatID, messageID)
+		return
+	}
+
+	h.bot.AnswerCallback(query.ID, "")
+	h.bot.RemoveKeyboard(chatID, messageID)
+	h.bot.SendTyping(chatID)
+	answer, err := h.bot.ContinueLiveOutput(ctx, chatID, messageID, feed)
+	if err != nil {
+		log.Errorf("Couldn't send answer: %v", err)
+		return
+	}
+	h.speak(ctx, chatID, messageID, answer)
+}
+
+// sendPrompt sends text to the conversation of the chat, along with the photo of the message and the file
+// waiting in the chat, and streams the answer as a reply to the message. The chat must be locked.
+func (h *handler) sendPrompt(ctx context.Context, settings *config.Settings, log *logger.Logger, message *tgbotapi.Message, text string) {
+	chatID, messageID := message.Chat.ID, message.MessageID
+	text = h.withDocument(chatID, h.withQuote(message, text))
+
+	var images []chatgpt.Image
+	if len(message.Photo) > 0 {
+		image, err := h.downloadPhoto(ctx, message)
+		if err != nil {
+			log.Warnf("Couldn't download photo: %v", err)
+			h.bot.Send(chatID, messageID, fmt.Sprintf("Error: %v", err))
+			return
+		}
+		images = append(images, image)
+	}
+
+	observePrompt(settings, message.From.ID, chatID)
+	h.bot.SendTyping(chatID)
+	log.With("prompt", logger.Content(text)).Debugf("Received prompt")
+
+	feed, err := h.chatGPT.SendMessage(ctx, text, chatID, images...)
+	if err != nil {
+		h.bot.Send(chatID, messageID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	answer, err := h.bot.SendAsLiveOutput(ctx, chatID, messageID, feed)
+	if err != nil {
+		log.Errorf("Couldn't send answer: %v", err)
+		return
+	}
+	h.speak(ctx, chatID, messageID, answer)
+}
+
+// speak sends the answer as a voice message replying to replyTo, if the chat has voice replies on.
+// Code blocks and formatting are left out, since they don't make sense out loud.
+func (h *handler) speak(ctx context.Context, chatID int64, replyTo int, answer string) {
+	if h.synthesizer == nil || !h.persistentConfig.HasVoiceReplies(chatID) {
+		return
+	}
+
+	text := markdown.StripFormatting(answer)
+	if text == "" {
+		return
+	}
+
+	// the voice reply follows the answer in the background, so the next updates don't wait for it
+	go func() {
+		ctx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), speechTimeout)
+		defer cancel()
+
+		ctx, span := tracing.StartSpan(ctx, "speech.synthesize")
+		audio, err := h.synthesizer.Synthesize(ctx, text)
+		if err == nil {
+			err = h.bot.SendVoice(chatID, replyTo, audio)
+		}
+		tracing.End(span, err)
+
+		if err != nil {
+			logger.With("chat_id", chatID).Warnf("Couldn't send voice reply: %v", err)
+		}
+	}()
+}
+
+// transcribe turns a voice or audio message into text, echoing it back so the user can see what was understood
+func (h *handler) transcribe(ctx context.Context, message *tgbotapi.Message) (text string, err error) {
+	if h.transcriber == nil {
+		return "", errors.New("Voice messages can't be understood, since no speech-to-text backend is set up")
+	}
+
+	ctx, span := tracing.StartSpan(ctx, "speech.transcribe")
+	defer func() { tracing.End(span, err) }()
+
+	fileID := ""
+	if message.Voice != nil {
+		fileID = message.Voice.FileID
+	} else {
+		fileID = message.Audio.FileID
+	}
+
+	h.bot.SendTyping(message.Chat.ID)
+	audio, name, err := h.bot.DownloadFile(ctx, fileID)
+	if err != nil {
+		return "", err
+	}
+
+	if text, err = h.transcriber.Transcribe(ctx, audio, name); err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't transcribe message: %v", err))
+	}
+	if text == "" {
+		return "", errors.New("No speech was recognized in the message")
+	}
+
+	if _, err := h.bot.Send(message.Chat.ID, message.MessageID, "🗣 "+text); err != nil {
+		logger.With("chat_id", message.Chat.ID).Warnf("Couldn't send transcription: %v", err)
+	}
+	return text, nil
+}
+
+// generateImages draws count images of prompt and sends them as photos replying to replyTo
+func (h *handler) generateImages(ctx context.Context, chatID int64, replyTo int, prompt string, size string, count int) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "imagegen.generate", trace.WithAttributes(
+		attribute.String("imagegen.size", size),
+		attribute.Int("imagegen.count", count),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	h.bot.SendUploadingPhoto(chatID)
+	images, err := h.generator.Generate(ctx, prompt, size, count)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		if err := h.bot.SendPhoto(chatID, replyTo, image); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't send image: %v", err))
+		}
+	}
+	return nil
+}
+
+// parseImageArgs splits the arguments of /image into the description and the size= and count= options before it
+func parseImageArgs(args string, defaultSize string, maxCount int) (prompt string, size string, count int, err error) {
+	size, count = defaultSize, 1
+
+	fields := strings.Fields(args)
+	for len(fields) > 0 {
+		key, value, _ := strings.Cut(fields[0], "=")
+		if strings.EqualFold(key, "size") {
+			if size = value; !config.ValidImageSize(size) {
+				return "", "", 0, errors.New(fmt.Sprintf("The size must be one of %s", strings.Join(config.IMAGE_SIZES, ", ")))
+			}
+		} else if strings.EqualFold(key, "count") {
+			if count, err = strconv.Atoi(value); err != nil || count < 1 || count > maxCount {
+				return "", "", 0, errors.New(fmt.Sprintf("The count must be between 1 and %d", maxCount))
+			}
+		} else {
+			break
+		}
+		fields = fields[1:]
+	}
+
+	if prompt = strings.Join(fields, " "); prompt == "" {
+		return "", "", 0, errors.New("Describe the image to draw")
+	}
+	return prompt, size, count, nil
+}
+
+// withQuote adds the message being forwarded, or replied to, as the context of text. Replies to the bot
+// or to the user's own messages are left as they are, since ChatGPT has already seen those.
+func (h *handler) withQuote(message *tgbotapi.Message, text string) string {
+	if message.ForwardDate != 0 {
+		if text == "" {
+			return text
+		}
+		return fmt.Sprintf("Regarding this message from %s:\n\n%s", forwardSender(message), quote(text))
+	}
+
+	reply := message.ReplyToMessage
+	if reply == nil || (reply.From != nil && (reply.From.ID == h.bot.ID || reply.From.ID == message.From.ID)) {
+		return text
+	}
+
+	quoted := reply.Text
+	if quoted == "" {
+		quoted = reply.Caption
+	}
+	if quoted == "" {
+		return text
+	}
+	return fmt.Sprintf("Regarding this message from %s:\n\n%s\n\n%s", sender(reply), quote(quoted), text)
+}
+
+// quote formats text as a Markdown quote
+func quote(text string) string {
+	return "> " + strings.ReplaceAll(strings.TrimSpace(text), "\n", "\n> ")
+}
+
+// sender is the name of whoever sent message
+func sender(message *tgbotapi.Message) string {
+	switch {
+	case message.SenderChat != nil:
+		return message.SenderChat.Title
+	case message.From != nil:
+		return strings.TrimSpace(message.From.FirstName + " " + message.From.LastName)
+	default:
+		return "someone"
+	}
+}
+
+// forwardSender is the name of whoever sent the original of a forwarded message
+func forwardSender(message *tgbotapi.Message) string {
+	switch {
+	case message.ForwardFromChat != nil:
+		return message.ForwardFromChat.Title
+	case message.ForwardFrom != nil:
+		return strings.TrimSpace(message.ForwardFrom.FirstName + " " + message.ForwardFrom.LastName)
+	case message.ForwardSenderName != "":
+		return message.ForwardSenderName
+	default:
+		return "someone"
+	}
+}
+
+// downloadPhoto returns the largest size of the photo in message
+func (h *handler) downloadPhoto(ctx context.Context, message *tgbotapi.Message) (chatgpt.Image, error) {
+	photo := message.Photo[len(message.Photo)-1]
+	for _, size := range message.Photo {
+		if size.Width*size.Height > photo.Width*photo.Height {
+			photo = size
+		}
+	}
+
+	data, _, err := h.bot.DownloadFile(ctx, photo.FileID)
+	if err != nil {
+		return chatgpt.Image{}, err
+	}
+
+	return chatgpt.Image{MimeType: http.DetectContentType(data), Data: data}, nil
+}
+
+// rateLimited records a prompt from the user, returning the reply to send if they're over the limit. Admins aren't limited.
+// observePrompt counts a prompt per user and chat. The allowlist bounds how many users there are,
+// so without one everyone is counted together.
+func observePrompt(settings *config.Settings, userID int64, chatID int64) {
+	if len(settings.TelegramID) == 0 && !settings.HasAdminID(userID) {
+		metrics.ObserveOtherPrompt()
+		return
+	}
+	metrics.ObservePrompt(userID, chatID)
+}
+
+func (h *handler) rateLimited(settings *config.Settings, userID int64) string {
+	return h.rateLimitedN(settings, userID, 1)
+}
+
+// rateLimitedN counts n prompts at once, so either all of them go through or none of them count
+func (h *handler) rateLimitedN(settings *config.Settings, userID int64, n int) string {
+	if settings.HasAdminID(userID) {
+		return ""
+	}
+
+	if ok, wait := h.limiter.AllowN(userID, n); !ok {
+		logger.With("user_id", userID).Infof("User is rate limited")
+		return fmt.Sprintf("You're sending messages too fast, try again in %v.", wait.Round(time.Second))
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxInlineAnswers is how many answers to inline queries are cached
+const maxInlineAnswers = 1000
+
+// inlineResultCacheTime is how long Telegram can show an inline result again without asking the bot
+const inlineResultCacheTime = time.Minute
+
+// handleInlineQuery answers "@bot question" from any chat with a one-off prompt, outside of the user's conversation.
+// Telegram sends a query for every change while typing, so only questions ending in ?, ! or . are sent to ChatGPT.
+func (h *handler) handleInlineQuery(ctx context.Context, settings *config.Settings, query *tgbotapi.InlineQuery) {
+	log := logger.With("user_id", query.From.ID)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("telegram.user_id", query.From.ID))
+
+	if !settings.IsAllowed(query.From.ID) {
+		log.Infof("User is not allowed to use this bot")
+		h.answerInlineHint(log, query.ID, "You are not authorized to use this bot.")
+		return
+	}
+
+	question := strings.TrimSpace(query.Query)
+	if question == "" || !strings.ContainsAny(question[len(question)-1:], "?!.") {
+		h.answerInlineHint(log, query.ID, "End your question with ? to ask ChatGPT")
+		return
+	}
+
+	// inlineAnswers is safe for concurrent use, so queries can be answered in the background
+	key := strings.ToLower(question)
+	answer, cached := h.inlineAnswers.Get(key)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("inline.cached", cached))
+	if cached {
+		h.answerInline(log, query.ID, question, answer)
+		return
+	}
+
+	if reply := h.rateLimited(settings, query.From.ID); reply != "" {
+		h.answerInlineHint(log, query.ID, reply)
+		return
+	}
+
+	observePrompt(settings, query.From.ID, 0)
+	log.With("prompt", logger.Content(question)).Debugf("Received inline query")
+
+	// waiting for ChatGPT would hold up the updates of every other chat
+	ctx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), time.Duration(settings.InlineTimeoutSeconds)*time.Second)
+	go func() {
+		defer cancel()
+
+		answer, err := h.chatGPT.Ask(ctx, "inline", question)
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Warnf("Inline query timed out")
+			h.answerInlineHint(log, query.ID, "ChatGPT took too long, message the bot instead")
+			return
+		}
+		if err != nil {
+			log.Warnf("Couldn't answer inline query: %v", err)
+			h.answerInlineHint(log, query.ID, "ChatGPT couldn't answer, try again later")
+			return
+		}
+
+		h.inlineAnswers.Set(key, answer, time.Duration(settings.InlineCacheMinutes)*time.Minute)
+		h.answerInline(log, query.ID, question, answer)
+	}()
+}
+
+func (h *handler) answerInline(log *logger.Logger, queryID string, question string, answer string) {
+	if err := h.bot.AnswerInline(queryID, question, "❓ "+question+"\n\n"+answer, inlineResultCacheTime); err != nil {
+		log.Errorf("Couldn't answer inline query: %v", err)
+	}
+}
+
+func (h *handler) answerInlineHint(log *logger.Logger, queryID string, hint string) {
+	if err := h.bot.AnswerInlineHint(queryID, hint); err != nil {
+		log.Warnf("Couldn't answer inline query: %v", err)
+	}
+}
@@ -1,107 +1,129 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 
-	"github.com/m1guelpf/chatgpt-telegram/src/chatgpt"
 	"github.com/m1guelpf/chatgpt-telegram/src/config"
-	"github.com/m1guelpf/chatgpt-telegram/src/session"
-	"github.com/m1guelpf/chatgpt-telegram/src/tgbot"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/spf13/pflag"
 )
 
-func main() {
-	persistentConfig, err := config.LoadOrCreatePersistentConfig()
-	if err != nil {
-		log.Fatalf("Couldn't load config: %v", err)
-	}
+// version is set when building, with -ldflags "-X main.version=..."
+var version = "dev"
 
-	if persistentConfig.OpenAISession == "" {
-		token, err := session.GetSession()
-		if err != nil {
-			log.Fatalf("Couldn't get OpenAI session: %v", err)
-		}
+type command struct {
+	name        string
+	description string
+	// setup registers the flags of the command, and returns the function that runs it
+	setup func(flags *pflag.FlagSet) func(opts config.LoadOptions) error
+}
 
-		if err = persistentConfig.SetSessionToken(token); err != nil {
-			log.Fatalf("Couldn't save OpenAI session: %v", err)
-		}
+var commands = []command{
+	{"run", "Start the bot (the default)", runCommand},
+	{"login", "Log in to ChatGPT with a browser and save the session", loginCommand},
+	{"logout", "Forget the saved session and browser data", logoutCommand},
+	{"check", "Check the config, the OpenAI accounts and the connection to Telegram", checkCommand},
+	{"export-history", "Export the conversations of website accounts as JSON lines", exportHistoryCommand},
+	{"rotate-key", "Re-encrypt the persistent config with a new key", rotateKeyCommand},
+	{"version", "Print the version", versionCommand},
+}
+
+func main() {
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	chatGPT := chatgpt.Init(persistentConfig)
-	log.Println("Started ChatGPT")
+	if name == "help" {
+		printUsage(nil, globalFlags(new(string), new(string)))
+		return
+	}
 
-	envConfig, err := config.LoadEnvConfig(".env")
-	if err != nil {
-		log.Fatalf("Couldn't load .env config: %v", err)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
 	}
-	if err := envConfig.ValidateWithDefaults(); err != nil {
-		log.Fatalf("Invalid .env config: %v", err)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %s, run %s help to see the available ones\n", name, os.Args[0])
+		os.Exit(2)
 	}
 
-	bot, err := tgbot.New(envConfig.TelegramToken, time.Duration(envConfig.EditWaitSeconds*int(time.Second)))
-	if err != nil {
-		log.Fatalf("Couldn't start Telegram bot: %v", err)
+	var envFile, configFile string
+	flags := globalFlags(&envFile, &configFile)
+	run := cmd.setup(flags)
+	flags.Usage = func() { printUsage(cmd, flags) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
 	}
 
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		bot.Stop()
-		os.Exit(0)
-	}()
+	if err := run(config.LoadOptions{EnvFile: envFile, File: configFile, Flags: flags}); err != nil {
+		logger.Fatalf("%v", err)
+	}
+}
 
-	log.Printf("Started Telegram bot! Message @%s to start.", bot.Username)
+// globalFlags returns a flag set with the flags shared by every command
+func globalFlags(envFile *string, configFile *string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
+	flags.StringVar(envFile, "env-file", ".env", ".env file to load settings from")
+	flags.StringVar(configFile, "config", "", "YAML, TOML or JSON config file")
+	config.RegisterFlags(flags)
+	return flags
+}
 
-	for update := range bot.GetUpdatesChan() {
-		if update.Message == nil {
-			continue
+func printUsage(cmd *command, flags *pflag.FlagSet) {
+	if cmd != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\nUsage: %s %s [flags]\n", cmd.description, os.Args[0], cmd.name)
+	} else {
+		fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+		for _, c := range commands {
+			fmt.Fprintf(os.Stderr, "  %-16s%s\n", c.name, c.description)
 		}
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n%s", flags.FlagUsages())
+}
 
-		var (
-			updateText      = update.Message.Text
-			updateChatID    = update.Message.Chat.ID
-			updateMessageID = update.Message.MessageID
-			updateUserID    = update.Message.From.ID
-		)
-
-		if len(envConfig.TelegramID) != 0 && !envConfig.HasTelegramID(updateUserID) {
-			log.Printf("User %d is not allowed to use this bot", updateUserID)
-			bot.Send(updateChatID, updateMessageID, "You are not authorized to use this bot.")
-			continue
-		}
+// app is what most commands need: the settings, merged with the persistent config
+type app struct {
+	settings         *config.Settings
+	persistentConfig *config.Config
+}
 
-		if !update.Message.IsCommand() {
-			bot.SendTyping(updateChatID)
+func newApp(opts config.LoadOptions) (*app, error) {
+	settings, err := config.Load(opts)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't load config: %v", err))
+	}
+	configureLogger(settings)
 
-			feed, err := chatGPT.SendMessage(updateText, updateChatID)
-			if err != nil {
-				bot.Send(updateChatID, updateMessageID, fmt.Sprintf("Error: %v", err))
-			} else {
-				bot.SendAsLiveOutput(updateChatID, updateMessageID, feed)
-			}
-			continue
-		}
+	encryptionKey, err := config.LoadEncryptionKey(settings.ConfigEncryptionKey, settings.ConfigEncryptionKeyFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't load encryption key: %v", err))
+	}
 
-		var text string
-		switch update.Message.Command() {
-		case "help":
-			text = "Send a message to start talking with ChatGPT. You can use /reload at any point to clear the conversation history and start from scratch (don't worry, it won't delete the Telegram messages)."
-		case "start":
-			text = "Send a message to start talking with ChatGPT. You can use /reload at any point to clear the conversation history and start from scratch (don't worry, it won't delete the Telegram messages)."
-		case "reload":
-			chatGPT.ResetConversation(updateChatID)
-			text = "Started a new conversation. Enjoy!"
-		default:
-			text = "Unknown command. Send /help to see a list of commands."
-		}
+	persistentConfig, err := config.LoadOrCreatePersistentConfig(encryptionKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't load config: %v", err))
+	}
+	settings.MergePersistent(persistentConfig)
 
-		if _, err := bot.Send(updateChatID, updateMessageID, text); err != nil {
-			log.Printf("Error sending message: %v", err)
-		}
+	return &app{settings: settings, persistentConfig: persistentConfig}, nil
+}
+
+// configureLogger applies the LOG_* settings, invalid values are reported when validating the settings
+func configureLogger(settings *config.Settings) {
+	if level, err := logger.ParseLevel(settings.LogLevel); err == nil {
+		logger.SetLevel(level)
+	}
+	if format, err := logger.ParseFormat(settings.LogFormat); err == nil {
+		logger.SetFormat(format)
 	}
+	logger.SetLogContent(settings.LogMessageContent)
 }
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/m1guelpf/chatgpt-telegram/src/chatgpt"
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/expirymap"
+	"github.com/m1guelpf/chatgpt-telegram/src/health"
+	"github.com/m1guelpf/chatgpt-telegram/src/imagegen"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/m1guelpf/chatgpt-telegram/src/metrics"
+	"github.com/m1guelpf/chatgpt-telegram/src/ratelimit"
+	"github.com/m1guelpf/chatgpt-telegram/src/retry"
+	"github.com/m1guelpf/chatgpt-telegram/src/session"
+	"github.com/m1guelpf/chatgpt-telegram/src/speech"
+	"github.com/m1guelpf/chatgpt-telegram/src/tgbot"
+	"github.com/m1guelpf/chatgpt-telegram/src/tracing"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stuckUpdateTimeout is how long handling an update can take before /healthz fails.
+// Answers are streamed while the update is handled, so it leaves room for long answers and retries.
+const stuckUpdateTimeout = 10 * time.Minute
+
+// readyCacheDuration is how often /readyz checks Telegram and the OpenAI accounts at most
+const readyCacheDuration = 30 * time.Second
+
+// readyTimeout is how long /readyz waits for Telegram and the OpenAI accounts before reporting the bot isn't ready
+const readyTimeout = 10 * time.Second
+
+func runCommand(flags *pflag.FlagSet) func(opts config.LoadOptions) error {
+	printConfig := flags.Bool("print-config", false, "print the configuration, with secrets redacted, and exit")
+
+	return func(opts config.LoadOptions) error {
+		return run(opts, *printConfig)
+	}
+}
+
+// run starts the bot, returning once it stops receiving updates
+func run(opts config.LoadOptions, printConfig bool) error {
+	a, err := newApp(opts)
+	if err != nil {
+		return err
+	}
+	settings, persistentConfig := a.settings, a.persistentConfig
+
+	if err := settings.ValidateWithDefaults(); err != nil {
+		return errors.New(fmt.Sprintf("Invalid config, %v", err))
+	}
+
+	if printConfig {
+		out, err := settings.Redacted()
+		if err != nil {
+			return errors.New(fmt.Sprintf("Couldn't print config: %v", err))
+		}
+		fmt.Print(string(out))
+		return nil
+	}
+
+	stopTracing := func(ctx context.Context) error { return nil }
+	if settings.OTLPEndpoint != "" {
+		if stopTracing, err = tracing.Start(settings.OTLPEndpoint, version); err != nil {
+			return err
+		}
+		logger.Infof("Sending traces to %s", settings.OTLPEndpoint)
+	}
+
+	token, err := loadSession(settings, persistentConfig)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't get OpenAI session: %v", err))
+	}
+	// again, for the session of the default account
+	settings.MergePersistent(persistentConfig)
+	if token == "" && len(settings.Accounts) == 0 {
+		if len(settings.TelegramAdminID) == 0 {
+			return errors.New("No OpenAI session found and browser login is disabled. Set TELEGRAM_ADMIN_ID to provide one through Telegram.")
+		}
+		logger.Warnf("No OpenAI session found, send /session <token> to the bot from an admin account to set it up")
+	}
+
+	chatGPT := chatgpt.Init(settings.Accounts)
+	chatGPT.SetModels(settings.Model, settings.APIModel)
+	chatGPT.SetImageHistory(settings.ImageHistoryMessages)
+	chatGPT.OnSessionTokenRotated = func(account string, token string) {
+		logger.Infof("OpenAI session token of account %s was rotated", account)
+		if err := persistentConfig.SetAccountSessionToken(account, token); err != nil {
+			logger.Errorf("Couldn't save rotated OpenAI session: %v", err)
+		}
+	}
+	logger.Infof("Started ChatGPT")
+
+	chatGPT.RetryPolicy = retry.Policy{
+		MaxAttempts: settings.RetryAttempts,
+		BaseDelay:   time.Duration(settings.RetryDelaySeconds) * time.Second,
+		MaxDelay:    time.Duration(settings.RetryMaxDelaySeconds) * time.Second,
+	}
+
+	bot, err := tgbot.New(settings.TelegramToken, settings.TelegramAPIEndpoint, time.Duration(settings.EditWaitSeconds*int(time.Second)))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't start Telegram bot: %v", err))
+	}
+
+	transcriber, err := speech.NewTranscriber(settings.TranscriptionBackend, settings.TranscriptionURL, settings.TranscriptionAPIKey, settings.TranscriptionModel)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't set up transcription: %v", err))
+	}
+
+	synthesizer, err := speech.NewSynthesizer(settings.TTSBackend, settings.TTSURL, settings.TTSAPIKey, settings.TTSModel, settings.TTSVoice, settings.TTSCommand)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't set up text-to-speech: %v", err))
+	}
+
+	generator, err := imagegen.NewGenerator(settings.ImageBackend, settings.ImageURL, settings.ImageAPIKey, settings.ImageModel)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't set up image generation: %v", err))
+	}
+
+	limiter := ratelimit.NewLimiter(settings.RateLimitMessages, time.Duration(settings.RateLimitWindowSeconds)*time.Second)
+
+	watcher := config.NewWatcher(opts, settings, func(settings *config.Settings) {
+		configureLogger(settings)
+		bot.SetEditInterval(time.Duration(settings.EditWaitSeconds) * time.Second)
+		chatGPT.SetModels(settings.Model, settings.APIModel)
+		chatGPT.SetImageHistory(settings.ImageHistoryMessages)
+		limiter.SetLimit(settings.RateLimitMessages, time.Duration(settings.RateLimitWindowSeconds)*time.Second)
+	})
+	if err := watcher.Start(); err != nil {
+		logger.Warnf("Config changes won't be applied until the bot restarts: %v", err)
+	}
+
+	checker := health.New(stuckUpdateTimeout, func(ctx context.Context) error {
+		if err := bot.Ping(); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't reach Telegram: %v", err))
+		}
+		return chatGPT.EnsureAuth(ctx)
+	}, readyTimeout, readyCacheDuration)
+
+	if settings.HTTPAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		checker.Register(mux)
+		if err := serveHTTP(settings.HTTPAddress, mux); err != nil {
+			return err
+		}
+	}
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		watcher.Close()
+		bot.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := stopTracing(ctx); err != nil {
+			logger.Warnf("Couldn't send pending traces: %v", err)
+		}
+		os.Exit(0)
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := watcher.Reload(); err != nil {
+				logger.Errorf("Ignoring config change: %v", err)
+			}
+		}
+	}()
+
+	go chatGPT.MonitorSession(
+		time.Duration(settings.SessionRenewHours)*time.Hour,
+		time.Duration(settings.SessionExpiryWarningDays)*24*time.Hour,
+		func(message string) {
+			for _, adminID := range watcher.Settings().TelegramAdminID {
+				if _, err := bot.Send(adminID, 0, message); err != nil {
+					logger.Errorf("Couldn't notify admin %d: %v", adminID, err)
+				}
+			}
+		},
+	)
+
+	h := &handler{
+		bot:              bot,
+		chatGPT:          chatGPT,
+		persistentConfig: persistentConfig,
+		limiter:          limiter,
+		transcriber:      transcriber,
+		synthesizer:      synthesizer,
+		generator:        generator,
+		documents:        expirymap.New[int64, pendingDocument](),
+		inlineAnswers:    expirymap.New(expirymap.WithMaxSize[string, string](maxInlineAnswers)),
+	}
+
+	logger.Infof("Started Telegram bot! Message @%s to start.", bot.Username)
+
+	for update := range bot.GetUpdatesChan() {
+		checker.StartUpdate()
+		// settings can be reloaded at any time
+		settings := watcher.Settings()
+		metrics.ObserveUpdate(updateType(update))
+		ctx, span := tracing.StartSpan(context.Background(), "telegram.update", trace.WithAttributes(
+			attribute.Int("telegram.update_id", update.UpdateID),
+			attribute.String("telegram.update_type", updateType(update)),
+		))
+
+		switch {
+		case update.CallbackQuery != nil:
+			h.handleCallback(ctx, settings, update.CallbackQuery)
+		case update.Message != nil:
+			h.handleMessage(ctx, settings, update.Message)
+		case update.InlineQuery != nil:
+			h.handleInlineQuery(ctx, settings, update.InlineQuery)
+		}
+		span.End()
+		checker.FinishUpdate()
+	}
+	return nil
+}
+
+// serveHTTP starts serving handler on address in the background, failing if the address can't be listened on
+func serveHTTP(address string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't listen on HTTP_ADDRESS: %v", err))
+	}
+
+	go func() {
+		if err := http.Serve(listener, handler); err != nil {
+			logger.Errorf("HTTP server stopped: %v", err)
+		}
+	}()
+	logger.Infof("Serving metrics and health checks on %s", listener.Addr())
+	return nil
+}
+
+// updateType names the kind of an update, for metrics
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.Message != nil && update.Message.IsCommand():
+		return "command"
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	default:
+		return "other"
+	}
+}
+
+// configuredSession reads the OpenAI session token of the default account from (in order) OPENAI_SESSION,
+// a secret file or a cookies export. It returns an empty token if none of them is set.
+func configuredSession(settings *config.Settings) (string, error) {
+	switch {
+	case settings.OpenAISession != "":
+		return settings.OpenAISession, nil
+	case settings.OpenAISessionFile != "":
+		return session.FromFile(settings.OpenAISessionFile)
+	case settings.OpenAICookiesFile != "":
+		return session.FromCookies(settings.OpenAICookiesFile)
+	default:
+		return "", nil
+	}
+}
+
+// savedSession gets the OpenAI session token of the default account from the persistent config or, when it has none yet,
+// from its configuration (see configuredSession). The persistent config wins since it holds the token as rotated by renewals.
+func savedSession(settings *config.Settings, persistentConfig *config.Config) (string, error) {
+	if token := persistentConfig.SessionToken(); token != "" {
+		if settings.OpenAISession != "" || settings.OpenAISessionFile != "" || settings.OpenAICookiesFile != "" {
+			logger.Debugf("Using the saved OpenAI session instead of the configured one, run logout to replace it")
+		}
+		return token, nil
+	}
+
+	return configuredSession(settings)
+}
+
+// loadSession gets the OpenAI session token of the default account (see savedSession), falling back to logging in
+// with a browser, and saves configured tokens to the persistent config.
+// It returns an empty token when browser login is disabled, or when other accounts are configured instead.
+func loadSession(settings *config.Settings, persistentConfig *config.Config) (string, error) {
+	token, err := savedSession(settings, persistentConfig)
+
+	switch {
+	case err != nil || token != "":
+	case settings.DisableBrowserLogin || len(settings.Accounts) > 0:
+		return "", nil
+	default:
+		token, err = session.GetSession()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if token != persistentConfig.SessionToken() {
+		if err := persistentConfig.SetSessionToken(token); err != nil {
+			return "", errors.New(fmt.Sprintf("Couldn't save OpenAI session: %v", err))
+		}
+	}
+
+	return token, nil
+}
+
+func formatPoolStatus(statuses []chatgpt.AccountStatus) string {
+	var text strings.Builder
+	for _, status := range statuses {
+		kind := "website"
+		if status.API {
+			kind = "API"
+		}
+
+		state := "available"
+		switch {
+		case status.Expired:
+			state = "expired"
+		case time.Now().Before(status.LimitedUntil):
+			state = fmt.Sprintf("rate limited until %s", status.LimitedUntil.Format(time.Kitchen))
+		case !status.Available:
+			state = "not set up"
+		}
+
+		fmt.Fprintf(&text, "%s (%s): %s, %d conversations", status.Name, kind, state, status.Conversations)
+		if !status.SessionExpiry.IsZero() {
+			fmt.Fprintf(&text, ", session expires %s", status.SessionExpiry.Format("2006-01-02"))
+		}
+		if status.LastError != "" {
+			fmt.Fprintf(&text, "\n  last error: %s", status.LastError)
+		}
+		text.WriteString("\n")
+	}
+
+	return text.String()
+}
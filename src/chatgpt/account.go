@@ -0,0 +1,187 @@
+package chatgpt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/expirymap"
+	"github.com/m1guelpf/chatgpt-telegram/src/metrics"
+)
+
+// rateLimitCooldown is how long a rate limited account is skipped for, unless the backend says otherwise
+const rateLimitCooldown = 10 * time.Minute
+
+type Account struct {
+	Name         string
+	SessionToken string
+	APIKey       string
+	BaseURL      string
+	Model        string
+
+	AccessTokenMap    *expirymap.ExpiryMap[string, string]
+	mu                sync.Mutex // protects following, and SessionToken
+	accessTokenExpiry time.Time
+	refreshing        *refreshCall
+	sessionExpiry     time.Time
+	limitedUntil      time.Time
+	expired           bool
+	lastError         string
+}
+
+// AccountStatus is a snapshot of an account's health
+type AccountStatus struct {
+	Name          string
+	API           bool
+	Available     bool
+	Expired       bool
+	LimitedUntil  time.Time
+	SessionExpiry time.Time
+	Conversations int
+	LastError     string
+}
+
+func newAccount(cfg config.Account) *Account {
+	account := &Account{
+		Name:           cfg.Name,
+		SessionToken:   cfg.SessionToken,
+		APIKey:         cfg.APIKey,
+		BaseURL:        cfg.BaseURL,
+		Model:          cfg.Model,
+		AccessTokenMap: expirymap.New[string, string](),
+	}
+
+	if account.IsAPI() && account.BaseURL == "" {
+		account.BaseURL = DEFAULT_API_URL
+	}
+
+	return account
+}
+
+// IsAPI reports whether the account talks to an OpenAI-compatible API rather than the ChatGPT website
+func (a *Account) IsAPI() bool {
+	return a.APIKey != ""
+}
+
+func (a *Account) available() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return !a.expired && time.Now().After(a.limitedUntil) && (a.APIKey != "" || a.SessionToken != "")
+}
+
+// reportError updates the account health after a failed request
+func (a *Account) reportError(err *UpstreamError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastError = err.Error()
+	metrics.ObserveUpstreamError(a.Name, string(err.Kind), err.StatusCode)
+
+	switch err.Kind {
+	case ErrorRateLimited:
+		cooldown := err.RetryAfter
+		if cooldown == 0 {
+			cooldown = rateLimitCooldown
+		}
+		a.limitedUntil = time.Now().Add(cooldown)
+	case ErrorUnauthorized:
+		if a.APIKey != "" {
+			a.expired = true
+		} else {
+			// the cached access token was revoked, make sure the next request gets a fresh one
+			a.AccessTokenMap.Delete(KEY_ACCESS_TOKEN)
+		}
+	}
+}
+
+func (a *Account) reportSuccess() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastError = ""
+}
+
+func (a *Account) status() AccountStatus {
+	available := a.available()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return AccountStatus{
+		Name:          a.Name,
+		API:           a.APIKey != "",
+		Available:     available,
+		Expired:       a.expired,
+		LimitedUntil:  a.limitedUntil,
+		SessionExpiry: a.sessionExpiry,
+		LastError:     a.lastError,
+	}
+}
+
+// pickAccount returns the account a conversation should use: the one it's bound to while it's available,
+// otherwise the available account with the fewest conversations. Only API accounts are picked if apiOnly is set.
+// It returns nil if no account is available.
+func (c *ChatGPT) pickAccount(current string, apiOnly bool) *Account {
+	if account := c.account(current); account != nil && account.available() && (account.IsAPI() || !apiOnly) {
+		return account
+	}
+
+	counts := c.conversationCounts()
+
+	var best *Account
+	for _, account := range c.Accounts {
+		if !account.available() || (apiOnly && !account.IsAPI()) {
+			continue
+		}
+		if best == nil || counts[account.Name] < counts[best.Name] {
+			best = account
+		}
+	}
+
+	return best
+}
+
+func (c *ChatGPT) hasAPIAccount() bool {
+	for _, account := range c.Accounts {
+		if account.IsAPI() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChatGPT) account(name string) *Account {
+	for _, account := range c.Accounts {
+		if account.Name == name {
+			return account
+		}
+	}
+	return nil
+}
+
+func (c *ChatGPT) conversationCounts() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, convo := range c.conversations {
+		if convo.Account != "" {
+			counts[convo.Account]++
+		}
+	}
+	return counts
+}
+
+// PoolStatus reports the health of every account
+func (c *ChatGPT) PoolStatus() []AccountStatus {
+	counts := c.conversationCounts()
+
+	statuses := make([]AccountStatus, 0, len(c.Accounts))
+	for _, account := range c.Accounts {
+		status := account.status()
+		status.Conversations = counts[account.Name]
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
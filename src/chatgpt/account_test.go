@@ -0,0 +1,107 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/retry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickAccount(t *testing.T) {
+	for label, test := range map[string]struct {
+		current string
+		apiOnly bool
+		// limited accounts are rate limited, expired ones can't log in
+		limited []string
+		expired []string
+		want    string
+	}{
+		"keeps the current account": {
+			current: "web",
+			want:    "web",
+		},
+		"picks the least busy account": {
+			want: "api",
+		},
+		"moves away from a limited account": {
+			current: "web",
+			limited: []string{"web"},
+			want:    "api",
+		},
+		"moves away from an expired account": {
+			current: "api",
+			expired: []string{"api"},
+			want:    "web",
+		},
+		"only API accounts take images": {
+			current: "web",
+			apiOnly: true,
+			want:    "api",
+		},
+		"nothing available": {
+			apiOnly: true,
+			expired: []string{"api", "other"},
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			c := Init([]config.Account{
+				{Name: "web", SessionToken: "session"},
+				{Name: "api", APIKey: "key"},
+				{Name: "other", APIKey: "key"},
+			})
+			// web and other already have a conversation each
+			c.saveConversation(1, Conversation{Account: "web"})
+			c.saveConversation(2, Conversation{Account: "other"})
+			for _, name := range test.limited {
+				c.account(name).limitedUntil = time.Now().Add(time.Minute)
+			}
+			for _, name := range test.expired {
+				c.account(name).expired = true
+			}
+
+			account := c.pickAccount(test.current, test.apiOnly)
+			if test.want == "" {
+				require.Nil(t, account)
+				return
+			}
+			require.Equal(t, test.want, account.Name)
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"message": "Slow down"}}`)
+	}))
+	t.Cleanup(limited.Close)
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\": \"1\", \"choices\": [{\"delta\": {\"content\": \"Hello\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(working.Close)
+
+	c := Init([]config.Account{
+		{Name: "limited", APIKey: "key", BaseURL: limited.URL},
+		{Name: "working", APIKey: "key", BaseURL: working.URL},
+	})
+	c.RetryPolicy = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	answer, err := c.Ask(context.Background(), "test", "Hi")
+	require.NoError(t, err)
+	require.Equal(t, "Hello", answer)
+
+	statuses := c.PoolStatus()
+	require.Equal(t, "Slow down (429 Too Many Requests)", statuses[0].LastError)
+	require.WithinDuration(t, time.Now().Add(time.Minute), statuses[0].LimitedUntil, 5*time.Second)
+	require.Empty(t, statuses[1].LastError)
+}
@@ -0,0 +1,173 @@
+package chatgpt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const DEFAULT_API_URL = "https://api.openai.com/v1"
+const DEFAULT_API_MODEL = "gpt-3.5-turbo"
+
+// maxHistory is how many messages of a conversation are kept for API accounts
+const maxHistory = 40
+
+const continuePrompt = "Continue exactly where you left off, without repeating anything."
+
+type HistoryMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Images are sent along with Content, as data URLs
+	Images []string `json:"-"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+// MarshalJSON sends messages with images as a list of content parts, and the rest as plain text
+func (m HistoryMessage) MarshalJSON() ([]byte, error) {
+	type plain HistoryMessage
+	if len(m.Images) == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	var parts []contentPart
+	if m.Content != "" {
+		parts = append(parts, contentPart{Type: "text", Text: m.Content})
+	}
+	for _, url := range m.Images {
+		parts = append(parts, contentPart{Type: "image_url", ImageURL: &imageURL{URL: url}})
+	}
+
+	return json.Marshal(struct {
+		Role    string        `json:"role"`
+		Content []contentPart `json:"content"`
+	}{m.Role, parts})
+}
+
+// Image is a picture sent along with a prompt
+type Image struct {
+	// MimeType is the format of Data, like image/jpeg
+	MimeType string
+	Data     []byte
+}
+
+func (i Image) dataURL() string {
+	return fmt.Sprintf("data:%s;base64,%s", i.MimeType, base64.StdEncoding.EncodeToString(i.Data))
+}
+
+// userMessage is the message sending p, which isn't a continuation
+func (p prompt) userMessage() HistoryMessage {
+	message := HistoryMessage{Role: "user", Content: p.text}
+	for _, image := range p.images {
+		message.Images = append(message.Images, image.dataURL())
+	}
+	return message
+}
+
+// CompletionRequest is the body sent to the chat completions endpoint of API accounts.
+type CompletionRequest struct {
+	Model    string           `json:"model"`
+	Messages []HistoryMessage `json:"messages"`
+	Stream   bool             `json:"stream"`
+}
+
+type CompletionChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newCompletionRequest(model string, convo Conversation, p prompt) CompletionRequest {
+	messages := make([]HistoryMessage, len(convo.History), len(convo.History)+1)
+	copy(messages, convo.History)
+
+	if p.continuation {
+		messages = append(messages, HistoryMessage{Role: "user", Content: continuePrompt})
+	} else {
+		messages = append(messages, p.userMessage())
+	}
+
+	return CompletionRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   true,
+	}
+}
+
+// parseCompletionChunk adds a streamed chunk to the answer
+func parseCompletionChunk(data string, ans *answer) error {
+	var chunk CompletionChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return fmt.Errorf("Couldn't decode message response: %v", err)
+	}
+
+	if chunk.Error != nil {
+		return errors.New(chunk.Error.Message)
+	}
+
+	ans.meta.MessageID = chunk.ID
+	ans.meta.Model = chunk.Model
+	for _, choice := range chunk.Choices {
+		ans.text += choice.Delta.Content
+		if choice.FinishReason != nil {
+			ans.meta.FinishReason = *choice.FinishReason
+		}
+	}
+
+	return nil
+}
+
+// recordHistory saves the last exchange of an API conversation. When continuing,
+// the previous answer is extended instead of recording the continue prompt.
+// Only the latest imageHistory messages with images keep them.
+func (convo *Conversation) recordHistory(p prompt, imageHistory int) {
+	if p.continuation && len(convo.History) > 0 && convo.History[len(convo.History)-1].Role == "assistant" {
+		convo.History[len(convo.History)-1].Content = convo.LastAnswer
+		return
+	}
+
+	history := make([]HistoryMessage, 0, len(convo.History)+2)
+	history = append(history, convo.History...)
+	history = append(history,
+		p.userMessage(),
+		HistoryMessage{Role: "assistant", Content: convo.LastAnswer},
+	)
+
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+
+	kept := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		if len(history[i].Images) == 0 {
+			continue
+		}
+		if kept < imageHistory {
+			kept++
+			continue
+		}
+
+		// leave a note, so the model knows there was an image it can no longer see
+		history[i].Content = strings.TrimSpace(history[i].Content + fmt.Sprintf("\n[%d image(s) no longer available]", len(history[i].Images)))
+		history[i].Images = nil
+	}
+	convo.History = history
+}
@@ -0,0 +1,85 @@
+package chatgpt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordHistory(t *testing.T) {
+	image := Image{MimeType: "image/png", Data: []byte("png")}
+
+	for label, test := range map[string]struct {
+		history      []HistoryMessage
+		lastAnswer   string
+		prompt       prompt
+		imageHistory int
+		want         []HistoryMessage
+	}{
+		"new exchange": {
+			history:    []HistoryMessage{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello"}},
+			lastAnswer: "Fine",
+			prompt:     prompt{text: "How are you?"},
+			want: []HistoryMessage{
+				{Role: "user", Content: "Hi"},
+				{Role: "assistant", Content: "Hello"},
+				{Role: "user", Content: "How are you?"},
+				{Role: "assistant", Content: "Fine"},
+			},
+		},
+		"continuation extends the last answer": {
+			history:    []HistoryMessage{{Role: "user", Content: "Count"}, {Role: "assistant", Content: "1, 2"}},
+			lastAnswer: "1, 2, 3",
+			prompt:     prompt{continuation: true},
+			want:       []HistoryMessage{{Role: "user", Content: "Count"}, {Role: "assistant", Content: "1, 2, 3"}},
+		},
+		"images are kept for the latest messages": {
+			lastAnswer:   "A cat",
+			prompt:       prompt{text: "What is it?", images: []Image{image}},
+			imageHistory: 1,
+			want: []HistoryMessage{
+				{Role: "user", Content: "What is it?", Images: []string{image.dataURL()}},
+				{Role: "assistant", Content: "A cat"},
+			},
+		},
+		"older images are replaced by a note": {
+			history:    []HistoryMessage{{Role: "user", Content: "What is it?", Images: []string{image.dataURL()}}, {Role: "assistant", Content: "A cat"}},
+			lastAnswer: "Grey",
+			prompt:     prompt{text: "Which color?"},
+			want: []HistoryMessage{
+				{Role: "user", Content: "What is it?\n[1 image(s) no longer available]"},
+				{Role: "assistant", Content: "A cat"},
+				{Role: "user", Content: "Which color?"},
+				{Role: "assistant", Content: "Grey"},
+			},
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			convo := Conversation{History: test.history, LastAnswer: test.lastAnswer}
+			convo.recordHistory(test.prompt, test.imageHistory)
+			require.Equal(t, test.want, convo.History)
+		})
+	}
+}
+
+func TestRecordHistoryIsBounded(t *testing.T) {
+	var convo Conversation
+	for i := 0; i < maxHistory; i++ {
+		convo.LastAnswer = fmt.Sprintf("answer %d", i)
+		convo.recordHistory(prompt{text: fmt.Sprintf("prompt %d", i)}, 0)
+	}
+
+	require.Len(t, convo.History, maxHistory)
+	require.Equal(t, HistoryMessage{Role: "assistant", Content: fmt.Sprintf("answer %d", maxHistory-1)}, convo.History[maxHistory-1])
+}
+
+func TestParseCompletionChunk(t *testing.T) {
+	var ans answer
+	require.NoError(t, parseCompletionChunk(`{"id": "1", "model": "gpt-4", "choices": [{"delta": {"content": "Hel"}, "finish_reason": null}]}`, &ans))
+	require.NoError(t, parseCompletionChunk(`{"id": "1", "model": "gpt-4", "choices": [{"delta": {"content": "lo"}, "finish_reason": "length"}]}`, &ans))
+	require.Equal(t, answer{text: "Hello", meta: Metadata{Model: "gpt-4", FinishReason: "length", MessageID: "1"}}, ans)
+
+	require.EqualError(t, parseCompletionChunk(`{"error": {"message": "Overloaded"}}`, &ans), "Overloaded")
+	require.Error(t, parseCompletionChunk(`not json`, &ans))
+}
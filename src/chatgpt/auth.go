@@ -0,0 +1,306 @@
+package chatgpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/m1guelpf/chatgpt-telegram/src/metrics"
+	"github.com/m1guelpf/chatgpt-telegram/src/session"
+	"github.com/m1guelpf/chatgpt-telegram/src/sse"
+	"github.com/m1guelpf/chatgpt-telegram/src/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type SessionResult struct {
+	Error       string `json:"error"`
+	Expires     string `json:"expires"`
+	AccessToken string `json:"accessToken"`
+}
+
+// refreshBefore is how long before expiring an access token is refreshed in the background
+const refreshBefore = 5 * time.Minute
+
+// sessionTimeout bounds fetching an access token, which is shared by every request of the account
+const sessionTimeout = 30 * time.Second
+
+// authSessionURL exchanges the session token for an access token, it's only changed in tests
+var authSessionURL = "https://chat.openai.com/api/auth/session"
+
+var authClient = &http.Client{Timeout: sessionTimeout}
+
+// refreshCall is an in-flight access token refresh, shared by everyone waiting on it
+type refreshCall struct {
+	done        chan struct{}
+	accessToken string
+	err         error
+}
+
+type authSession struct {
+	AccessToken string
+	Expires     time.Time
+	// SessionToken is set when the backend rotated the session token
+	SessionToken string
+}
+
+var (
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrSessionExpired = errors.New("Session token has expired")
+)
+
+// SetSessionToken replaces the session token of a website account, after making sure it's valid.
+func (c *ChatGPT) SetSessionToken(ctx context.Context, accountName string, sessionToken string) error {
+	account := c.account(accountName)
+	if account == nil {
+		return errors.New(fmt.Sprintf("Unknown account %s", accountName))
+	}
+	if account.IsAPI() {
+		return errors.New(fmt.Sprintf("Account %s uses an API key", accountName))
+	}
+
+	session, err := fetchSession(ctx, sessionToken)
+	if err != nil {
+		return err
+	}
+
+	c.applySession(account, sessionToken, session)
+	return nil
+}
+
+// CheckAccount makes sure the credentials of an account work, by getting an access token
+// for website accounts, or listing the models of API accounts.
+func (c *ChatGPT) CheckAccount(ctx context.Context, accountName string) error {
+	account := c.account(accountName)
+	if account == nil {
+		return errors.New(fmt.Sprintf("Unknown account %s", accountName))
+	}
+
+	if !account.IsAPI() {
+		_, err := c.refreshAccessToken(ctx, account)
+		return err
+	}
+
+	var models struct{}
+	return c.get(ctx, account, strings.TrimSuffix(account.BaseURL, "/")+"/models", &models)
+}
+
+// MonitorSession renews the session of every website account each interval, calling notify when renewing
+// fails (and recovers) and, at most once a day, when a session expires in less than warnBefore. It doesn't return.
+func (c *ChatGPT) MonitorSession(interval time.Duration, warnBefore time.Duration, notify func(message string)) {
+	failing := make(map[string]bool)
+	lastWarning := make(map[string]time.Time)
+
+	for ; ; time.Sleep(interval) {
+		for _, account := range c.Accounts {
+			account.mu.Lock()
+			hasSession := account.SessionToken != ""
+			account.mu.Unlock()
+			if account.IsAPI() || !hasSession {
+				continue
+			}
+
+			if err := c.renewSession(account); err != nil {
+				logger.Errorf("Couldn't renew OpenAI session of account %s: %v", account.Name, err)
+				if !failing[account.Name] {
+					notify(fmt.Sprintf("Couldn't renew the OpenAI session of account %s: %v. Send /session %s <token> with a new session token to fix it.", account.Name, err, account.Name))
+				}
+				failing[account.Name] = true
+				continue
+			}
+
+			if failing[account.Name] {
+				notify(fmt.Sprintf("The OpenAI session of account %s was renewed successfully.", account.Name))
+				failing[account.Name] = false
+			}
+
+			account.mu.Lock()
+			expiry := account.sessionExpiry
+			account.mu.Unlock()
+
+			if left := time.Until(expiry); left < warnBefore && time.Since(lastWarning[account.Name]) > 24*time.Hour {
+				notify(fmt.Sprintf("The OpenAI session of account %s expires in %s (%s). Send /session %s <token> with a new session token to avoid downtime.", account.Name, left.Round(time.Hour), expiry.Format(time.RFC1123), account.Name))
+				lastWarning[account.Name] = time.Now()
+			}
+		}
+	}
+}
+
+// renewSession fetches a new access token even if the cached one is still valid,
+// which also gets the backend to rotate the session token when it's getting old.
+func (c *ChatGPT) renewSession(account *Account) error {
+	call := c.startRefresh(account)
+	<-call.done
+	return call.err
+}
+
+// applySession stores a freshly fetched session, switching to the rotated session token if there's one
+func (c *ChatGPT) applySession(account *Account, sessionToken string, session authSession) {
+	rotated := session.SessionToken != "" && session.SessionToken != sessionToken
+
+	account.mu.Lock()
+	if rotated {
+		sessionToken = session.SessionToken
+	}
+	account.SessionToken = sessionToken
+	account.sessionExpiry = session.Expires
+	account.accessTokenExpiry = session.Expires
+	account.expired = false
+	account.mu.Unlock()
+
+	account.AccessTokenMap.Set(KEY_ACCESS_TOKEN, session.AccessToken, time.Until(session.Expires))
+
+	if rotated && c.OnSessionTokenRotated != nil {
+		c.OnSessionTokenRotated(account.Name, sessionToken)
+	}
+}
+
+// refreshAccessToken returns the cached access token of the account, fetching a new one if it has expired.
+// Tokens close to expiring are refreshed in the background, and concurrent callers share a single refresh.
+func (c *ChatGPT) refreshAccessToken(ctx context.Context, account *Account) (string, error) {
+	_, span := tracing.StartSpan(ctx, "chatgpt.refresh_access_token", trace.WithAttributes(attribute.String("chatgpt.account", account.Name)))
+
+	cachedAccessToken, ok := account.AccessTokenMap.Get(KEY_ACCESS_TOKEN)
+	if ok {
+		account.mu.Lock()
+		expiresSoon := time.Until(account.accessTokenExpiry) < refreshBefore
+		account.mu.Unlock()
+
+		if expiresSoon {
+			c.startRefresh(account)
+		}
+		span.SetAttributes(attribute.Bool("chatgpt.cached", true), attribute.Bool("chatgpt.expires_soon", expiresSoon))
+		tracing.End(span, nil)
+		return cachedAccessToken, nil
+	}
+
+	// the refresh carries on without us if ctx is cancelled, other callers may be waiting on it
+	call := c.startRefresh(account)
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		tracing.End(span, ctx.Err())
+		return "", ctx.Err()
+	}
+	span.SetAttributes(attribute.Bool("chatgpt.cached", false))
+	tracing.End(span, call.err)
+	return call.accessToken, call.err
+}
+
+// startRefresh fetches a new access token for the account, unless a refresh is already in flight,
+// in which case that one is returned. The call's done channel is closed once it has finished.
+func (c *ChatGPT) startRefresh(account *Account) *refreshCall {
+	account.mu.Lock()
+	if account.refreshing != nil {
+		call := account.refreshing
+		account.mu.Unlock()
+		return call
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	account.refreshing = call
+	sessionToken := account.SessionToken
+	account.mu.Unlock()
+
+	go func() {
+		defer close(call.done)
+		defer func() {
+			account.mu.Lock()
+			account.refreshing = nil
+			account.mu.Unlock()
+		}()
+
+		if sessionToken == "" {
+			call.err = errors.New("No session token has been set up yet")
+			return
+		}
+
+		// the refresh is shared, so it isn't cancelled with any one caller's context
+		session, err := fetchSession(context.Background(), sessionToken)
+		metrics.ObserveAuthRefresh(account.Name, err)
+		if err != nil {
+			account.reportAuthError(err)
+			call.err = err
+			return
+		}
+
+		c.applySession(account, sessionToken, session)
+		call.accessToken = session.AccessToken
+	}()
+
+	return call
+}
+
+// reportAuthError takes the account out of the pool if the backend rejected its session. Other failures,
+// like rate limits or outages, leave it in, since the next refresh may well work.
+func (a *Account) reportAuthError(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastError = err.Error()
+
+	var upstreamErr *UpstreamError
+	rejected := errors.As(err, &upstreamErr) && upstreamErr.Kind != ErrorChallenge &&
+		(upstreamErr.StatusCode == http.StatusUnauthorized || upstreamErr.StatusCode == http.StatusForbidden)
+	if rejected || errors.Is(err, ErrSessionExpired) {
+		a.expired = true
+	}
+}
+
+// fetchSession exchanges a session token for an access token, giving up after sessionTimeout
+func fetchSession(ctx context.Context, sessionToken string) (authSession, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", authSessionURL, nil)
+	if err != nil {
+		return authSession{}, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("User-Agent", USER_AGENT)
+	req.Header.Set("Cookie", fmt.Sprintf("%s=%s", session.COOKIE_NAME, sessionToken))
+
+	res, err := authClient.Do(req)
+	if err != nil {
+		return authSession{}, fmt.Errorf("failed to perform request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+		return authSession{}, classifyError(&sse.StatusError{StatusCode: res.StatusCode, Status: res.Status, Header: res.Header, Body: body})
+	}
+
+	var result SessionResult
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return authSession{}, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.Error != "" {
+		if result.Error == "RefreshAccessTokenError" {
+			return authSession{}, ErrSessionExpired
+		}
+
+		return authSession{}, errors.New(result.Error)
+	}
+
+	accessToken := result.AccessToken
+	if accessToken == "" {
+		return authSession{}, ErrUnauthorized
+	}
+
+	expiryTime, err := time.Parse(time.RFC3339, result.Expires)
+	if err != nil {
+		return authSession{}, fmt.Errorf("failed to parse expiry time: %v", err)
+	}
+
+	return authSession{
+		AccessToken:  accessToken,
+		Expires:      expiryTime,
+		SessionToken: session.TokenFromCookies(res.Cookies()),
+	}, nil
+}
@@ -0,0 +1,195 @@
+package chatgpt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/session"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingSessionServer answers session requests with status and body once release is closed
+func blockingSessionServer(t *testing.T, status int, body string) (requests *int32, started chan struct{}, release chan struct{}) {
+	requests = new(int32)
+	started = make(chan struct{}, 10)
+	release = make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	previous := authSessionURL
+	authSessionURL = server.URL
+	t.Cleanup(func() { authSessionURL = previous })
+
+	return requests, started, release
+}
+
+func TestRefreshIsShared(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	requests, started, release := blockingSessionServer(t, http.StatusOK, `{"accessToken": "access", "expires": "`+expires+`"}`)
+
+	c := Init([]config.Account{{Name: "a", SessionToken: "session"}})
+	account := c.Accounts[0]
+
+	call := c.startRefresh(account)
+	<-started
+	for i := 0; i < 5; i++ {
+		require.Same(t, call, c.startRefresh(account))
+	}
+
+	results := make(chan string)
+	for i := 0; i < 5; i++ {
+		go func() {
+			token, err := c.refreshAccessToken(context.Background(), account)
+			require.NoError(t, err)
+			results <- token
+		}()
+	}
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, "access", <-results)
+	}
+	<-call.done
+
+	require.Equal(t, "access", call.accessToken)
+	require.EqualValues(t, 1, atomic.LoadInt32(requests))
+	account.mu.Lock()
+	require.Nil(t, account.refreshing)
+	account.mu.Unlock()
+}
+
+func TestRefreshSharesErrors(t *testing.T) {
+	requests, started, release := blockingSessionServer(t, http.StatusUnauthorized, `{}`)
+
+	c := Init([]config.Account{{Name: "a", SessionToken: "session"}})
+	account := c.Accounts[0]
+
+	first, second := c.startRefresh(account), c.startRefresh(account)
+	<-started
+	close(release)
+	<-first.done
+
+	require.Same(t, first, second)
+	var upstreamErr *UpstreamError
+	require.ErrorAs(t, first.err, &upstreamErr)
+	require.Equal(t, ErrorUnauthorized, upstreamErr.Kind)
+	require.EqualValues(t, 1, atomic.LoadInt32(requests))
+	account.mu.Lock()
+	require.Nil(t, account.refreshing)
+	require.True(t, account.expired)
+	account.mu.Unlock()
+}
+
+func TestRefreshWaitersCanGiveUp(t *testing.T) {
+	_, started, release := blockingSessionServer(t, http.StatusOK, `{}`)
+
+	c := Init([]config.Account{{Name: "a", SessionToken: "session"}})
+	account := c.Accounts[0]
+
+	call := c.startRefresh(account)
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.refreshAccessToken(ctx, account)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// the refresh itself carries on for everyone else
+	close(release)
+	<-call.done
+	require.ErrorIs(t, call.err, ErrUnauthorized)
+}
+
+func TestRefreshOnlyExpiresRejectedSessions(t *testing.T) {
+	for label, test := range map[string]struct {
+		status      int
+		body        string
+		wantErr     error
+		wantExpired bool
+	}{
+		"unauthorized":          {status: http.StatusUnauthorized, body: `{}`, wantExpired: true},
+		"forbidden":             {status: http.StatusForbidden, body: `{}`, wantExpired: true},
+		"cloudflare challenge":  {status: http.StatusForbidden, body: `<title>Just a moment...</title>`},
+		"rate limited":          {status: http.StatusTooManyRequests, body: `{}`},
+		"unavailable":           {status: http.StatusServiceUnavailable, body: `{}`},
+		"no access token":       {status: http.StatusOK, body: `{}`, wantErr: ErrUnauthorized},
+		"refresh token expired": {status: http.StatusOK, body: `{"error": "RefreshAccessTokenError"}`, wantErr: ErrSessionExpired, wantExpired: true},
+	} {
+		t.Run(label, func(t *testing.T) {
+			_, _, release := blockingSessionServer(t, test.status, test.body)
+			close(release)
+
+			c := Init([]config.Account{{Name: "a", SessionToken: "session"}})
+			account := c.Accounts[0]
+
+			_, err := c.refreshAccessToken(context.Background(), account)
+			require.Error(t, err)
+			if test.wantErr != nil {
+				require.ErrorIs(t, err, test.wantErr)
+			}
+			require.Equal(t, test.wantExpired, !account.available())
+		})
+	}
+}
+
+func TestRenewSession(t *testing.T) {
+	for label, test := range map[string]struct {
+		cookie      string
+		wantSession string
+		wantRotated bool
+	}{
+		"rotated":    {cookie: "rotated", wantSession: "rotated", wantRotated: true},
+		"same token": {cookie: "session", wantSession: "session"},
+		"no cookie":  {wantSession: "session"},
+	} {
+		t.Run(label, func(t *testing.T) {
+			expires := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+			requests := new(int32)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(requests, 1)
+				if test.cookie != "" {
+					http.SetCookie(w, &http.Cookie{Name: session.COOKIE_NAME, Value: test.cookie})
+				}
+				w.Write([]byte(`{"accessToken": "access", "expires": "` + expires.Format(time.RFC3339) + `"}`))
+			}))
+			t.Cleanup(server.Close)
+
+			previous := authSessionURL
+			authSessionURL = server.URL
+			t.Cleanup(func() { authSessionURL = previous })
+
+			c := Init([]config.Account{{Name: "a", SessionToken: "session"}})
+			account := c.Accounts[0]
+			var rotated []string
+			c.OnSessionTokenRotated = func(account string, token string) { rotated = append(rotated, account, token) }
+
+			// renewing fetches a new access token even if the cached one is still valid
+			account.AccessTokenMap.Set(KEY_ACCESS_TOKEN, "cached", time.Hour)
+			require.NoError(t, c.renewSession(account))
+			require.EqualValues(t, 1, atomic.LoadInt32(requests))
+
+			account.mu.Lock()
+			require.Equal(t, test.wantSession, account.SessionToken)
+			require.Equal(t, expires, account.sessionExpiry.UTC())
+			account.mu.Unlock()
+
+			if test.wantRotated {
+				require.Equal(t, []string{"a", test.wantSession}, rotated)
+			} else {
+				require.Empty(t, rotated)
+			}
+		})
+	}
+}
@@ -1,71 +1,157 @@
 package chatgpt
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/m1guelpf/chatgpt-telegram/src/config"
-	"github.com/m1guelpf/chatgpt-telegram/src/expirymap"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/m1guelpf/chatgpt-telegram/src/metrics"
+	"github.com/m1guelpf/chatgpt-telegram/src/retry"
 	"github.com/m1guelpf/chatgpt-telegram/src/sse"
+	"github.com/m1guelpf/chatgpt-telegram/src/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const KEY_ACCESS_TOKEN = "accessToken"
+
+// hideTimeout bounds hiding a one-off conversation from the list of a website account
+const hideTimeout = 30 * time.Second
 const USER_AGENT = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/537.36"
 
 type Conversation struct {
+	// Account is the name of the account the conversation lives in
+	Account       string
 	ID            string
 	LastMessageID string
+	// LastAnswer is the full text of LastMessageID, used to continue it
+	LastAnswer string
+	// History is only kept for API accounts, website conversations are stored by ChatGPT
+	History []HistoryMessage
+}
+
+// moveTo prepares the conversation to continue in another account. Website conversations
+// are bound to the account they were started in, so only API conversations keep their history.
+func (convo Conversation) moveTo(account *Account, from *Account) Conversation {
+	if account.IsAPI() && from != nil && from.IsAPI() {
+		convo.Account = account.Name
+		return convo
+	}
+
+	return Conversation{Account: account.Name}
+}
+
+// prompt is what's sent to the backend: a new message, or a request to continue the last answer
+type prompt struct {
+	text         string
+	images       []Image
+	continuation bool
+	// oneOff prompts prefer API accounts, since website accounts keep them in their conversation list
+	oneOff bool
 }
 
 type ChatGPT struct {
-	SessionToken   string
-	AccessTokenMap expirymap.ExpiryMap
-	mu             sync.Mutex // protects following
-	conversations  map[int64]Conversation
+	Accounts    []*Account
+	RetryPolicy retry.Policy
+	// OnSessionTokenRotated is called when the backend replaces the session token of an account, so it can be saved
+	OnSessionTokenRotated func(account string, sessionToken string)
+	mu                    sync.Mutex // protects following
+	conversations         map[int64]Conversation
+	// model and apiModel are used by accounts that don't set a model
+	model    string
+	apiModel string
+	// imageHistory is how many messages of API conversations keep their images in the history
+	imageHistory int
 }
 
-type SessionResult struct {
-	Error       string `json:"error"`
-	Expires     string `json:"expires"`
-	AccessToken string `json:"accessToken"`
+func Init(accounts []config.Account) *ChatGPT {
+	if len(accounts) == 0 {
+		// keep a default account around, so a session token can be set up later
+		accounts = append(accounts, config.Account{Name: config.DefaultAccount})
+	}
+
+	c := &ChatGPT{
+		RetryPolicy:   retry.DefaultPolicy(),
+		mu:            sync.Mutex{},
+		conversations: make(map[int64]Conversation),
+	}
+	for _, account := range accounts {
+		c.Accounts = append(c.Accounts, newAccount(account))
+	}
+
+	return c
 }
 
-type MessageResponse struct {
-	ConversationId string `json:"conversation_id"`
-	Error          string `json:"error"`
-	Message        struct {
-		ID      string `json:"id"`
-		Content struct {
-			Parts []string `json:"parts"`
-		} `json:"content"`
-	} `json:"message"`
+// SetModels changes the models used by accounts that don't set one. Empty values restore the built-in defaults.
+func (c *ChatGPT) SetModels(model string, apiModel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.model, c.apiModel = model, apiModel
 }
 
-type ChatResponse struct {
-	Message string
+// SetImageHistory changes how many messages with images keep them in the history of API conversations.
+// Older images are dropped, so they aren't sent again with every prompt.
+func (c *ChatGPT) SetImageHistory(messages int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.imageHistory = messages
 }
 
-func Init(config *config.Config) *ChatGPT {
-	return &ChatGPT{
-		AccessTokenMap: expirymap.New(),
-		SessionToken:   config.OpenAISession,
-		mu:             sync.Mutex{},
-		conversations:  make(map[int64]Conversation),
+// modelFor returns the model the account should use
+func (c *ChatGPT) modelFor(account *Account) string {
+	if account.Model != "" {
+		return account.Model
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case account.IsAPI() && c.apiModel != "":
+		return c.apiModel
+	case account.IsAPI():
+		return DEFAULT_API_MODEL
+	case c.model != "":
+		return c.model
+	default:
+		return DEFAULT_MODEL
 	}
 }
 
 func (c *ChatGPT) IsAuthenticated() bool {
-	_, err := c.refreshAccessToken()
-	return err == nil
+	return c.EnsureAuth(context.Background()) == nil
 }
 
-func (c *ChatGPT) EnsureAuth() error {
-	_, err := c.refreshAccessToken()
+// EnsureAuth checks that at least one account can be used
+func (c *ChatGPT) EnsureAuth(ctx context.Context) error {
+	var err error
+	for _, account := range c.Accounts {
+		if !account.available() {
+			continue
+		}
+		if account.IsAPI() {
+			return nil
+		}
+		if _, err = c.refreshAccessToken(ctx, account); err == nil {
+			return nil
+		}
+	}
+
+	if err == nil {
+		err = errors.New("No account is available")
+	}
 	return err
 }
 
@@ -76,107 +162,432 @@ func (c *ChatGPT) ResetConversation(chatID int64) {
 	c.conversations[chatID] = Conversation{}
 }
 
-func (c *ChatGPT) SendMessage(message string, tgChatID int64) (chan ChatResponse, error) {
-	accessToken, err := c.refreshAccessToken()
+// SendMessage sends message to the conversation of the chat, streaming the answer. Images can only be sent
+// to API accounts, with a model that supports them. ctx is only used for tracing, the answer isn't cancelled with it.
+func (c *ChatGPT) SendMessage(ctx context.Context, message string, tgChatID int64, images ...Image) (chan ChatResponse, error) {
+	if len(images) > 0 && !c.hasAPIAccount() {
+		return nil, errors.New("Images can only be sent to accounts that use an API key")
+	}
+
+	return c.stream(ctx, tgChatID, prompt{text: message, images: images})
+}
+
+// Continue asks ChatGPT to carry on writing the last answer of the conversation, which must be messageID
+// (an empty messageID continues whatever the last answer is).
+// The responses only contain the text generated after the previous answer.
+func (c *ChatGPT) Continue(ctx context.Context, tgChatID int64, messageID string) (chan ChatResponse, error) {
+	c.mu.Lock() // lock the map to avoid data racing
+	convo := c.conversations[tgChatID]
+	c.mu.Unlock()
+
+	if convo.LastMessageID == "" {
+		return nil, errors.New("There's no answer to continue")
+	}
+	if messageID != "" && convo.LastMessageID != messageID {
+		return nil, errors.New("This answer can no longer be continued")
+	}
+
+	return c.stream(ctx, tgChatID, prompt{continuation: true})
+}
+
+// Ask sends a one-off prompt outside of any chat conversation, returning the whole answer once it's complete.
+// API accounts are preferred, when a website account answers the new conversation is hidden from its list afterwards.
+// kind says what the prompt is for in logs and traces, like inline or summary. The request is cancelled with ctx.
+func (c *ChatGPT) Ask(ctx context.Context, kind string, text string) (_ string, err error) {
+	ctx, span := tracing.StartSpan(ctx, "chatgpt.ask", trace.WithAttributes(attribute.String("chatgpt.kind", kind)))
+	defer func() { tracing.End(span, err) }()
+
+	// stop the event stream if we return before it ends
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	log := logger.With("kind", kind)
+	client, account, _, err := c.connect(ctx, log, Conversation{}, prompt{text: text, oneOff: true}, func(ChatResponse) {})
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Couldn't get access token: %v", err))
+		return "", errors.New(fmt.Sprintf("Couldn't connect to ChatGPT: %v", err))
 	}
+	span.SetAttributes(attribute.String("chatgpt.account", account.Name))
+
+	var ans answer
+	var firstChunk time.Duration
+	for event := range client.EventChannel {
+		if event.Err != nil {
+			return "", event.Err
+		}
+		if event.Data == "[DONE]" {
+			break
+		}
+		if err = account.parseEvent(event.Data, &ans); err != nil {
+			account.reportError(&UpstreamError{Kind: ErrorStream, Err: err})
+			return "", err
+		}
+		if firstChunk == 0 && ans.text != "" {
+			firstChunk = time.Since(start)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if ans.text == "" {
+		return "", errors.New("ChatGPT returned an empty response")
+	}
+
+	metrics.ObserveAnswer(account.Name, firstChunk, time.Since(start))
+	log.With("account", account.Name, "latency_ms", time.Since(start).Milliseconds(), "answer", logger.Content(ans.text)).Debugf("Answered")
 
-	client := sse.Init("https://chat.openai.com/backend-api/conversation")
+	if !account.IsAPI() && ans.meta.ConversationID != "" {
+		// the answer is ready, so don't make the caller wait (or run out of time) for this
+		go func() {
+			ctx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), span), hideTimeout)
+			defer cancel()
 
-	client.Headers = map[string]string{
-		"User-Agent":    USER_AGENT,
-		"Authorization": fmt.Sprintf("Bearer %s", accessToken),
+			if err := c.hideConversation(ctx, account, ans.meta.ConversationID); err != nil {
+				log.With("account", account.Name, "conversation_id", ans.meta.ConversationID).Warnf("Couldn't hide one-off conversation: %v", err)
+			}
+		}()
 	}
+	return ans.text, nil
+}
+
+// hideConversation removes a conversation from the list of a website account, like deleting it on the website does
+func (c *ChatGPT) hideConversation(ctx context.Context, account *Account, conversationID string) error {
+	return c.do(ctx, account, "PATCH", "https://chat.openai.com/backend-api/conversation/"+url.PathEscape(conversationID), map[string]bool{"is_visible": false}, nil)
+}
 
+// stream sends p to ChatGPT and forwards the answer, keeping the conversation up to date as it arrives.
+func (c *ChatGPT) stream(ctx context.Context, tgChatID int64, p prompt) (chan ChatResponse, error) {
 	c.mu.Lock() // lock the map to avoid data racing
 	convo := c.conversations[tgChatID]
 	c.mu.Unlock()
 
-	err = client.Connect(message, convo.ID, convo.LastMessageID)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Couldn't connect to ChatGPT: %v", err))
+	// when continuing, the answer so far is removed from the responses
+	var prefix string
+	if p.continuation {
+		prefix = convo.LastAnswer
 	}
 
 	r := make(chan ChatResponse)
+	start := time.Now()
+	log := logger.With("chat_id", tgChatID)
+
+	_, span := tracing.StartSpan(ctx, "chatgpt.stream", trace.WithAttributes(
+		attribute.Int64("telegram.chat_id", tgChatID),
+		attribute.Bool("chatgpt.continuation", p.continuation),
+	))
 
 	go func() {
 		defer close(r)
-		for {
-			select {
-			case chunk, ok := <-client.EventChannel:
-				if !ok {
-					return
-				}
 
-				var res MessageResponse
-				err := json.Unmarshal([]byte(chunk), &res)
-				if err != nil {
-					log.Printf("Couldn't unmarshal message response: %v", err)
-					continue
-				}
+		var err error
+		defer func() { tracing.End(span, err) }()
+
+		// the answer outlives the caller, so only the trace is kept from its context
+		ctx, cancel := context.WithCancel(trace.ContextWithSpan(context.Background(), span))
+		// stop the event stream if we return before it ends
+		defer cancel()
+
+		client, account, convo, err := c.connect(ctx, log, convo, p, func(response ChatResponse) { r <- response })
+		if err != nil {
+			log.With("latency_ms", time.Since(start).Milliseconds()).Errorf("Couldn't connect to ChatGPT: %v", err)
+			r <- ChatResponse{Type: ResponseError, Err: fmt.Errorf("Couldn't connect to ChatGPT: %v", err)}
+			return
+		}
+		log = log.With("account", account.Name)
+		span.SetAttributes(attribute.String("chatgpt.account", account.Name))
+		_, firstChunkSpan := tracing.StartSpan(ctx, "chatgpt.first_chunk")
+		defer firstChunkSpan.End()
+
+		var ans answer
+		var last ChatResponse
+		var done bool
+		// firstChunk is how long it took for the answer to start arriving
+		var firstChunk time.Duration
+		for event := range client.EventChannel {
+			if event.Err != nil {
+				log.With("conversation_id", convo.ID).Warnf("ChatGPT stream was interrupted: %v", event.Err)
+				break
+			}
+			if event.Data == "[DONE]" {
+				done = true
+				break
+			}
 
-				if len(res.Message.Content.Parts) > 0 {
-					convo.ID = res.ConversationId
-					convo.LastMessageID = res.Message.ID
+			if err = account.parseEvent(event.Data, &ans); err != nil {
+				account.reportError(&UpstreamError{Kind: ErrorStream, Err: err})
+				r <- ChatResponse{Type: ResponseError, Err: err}
+				return
+			}
 
-					c.mu.Lock() // lock the map to avoid data racing
-					c.conversations[tgChatID] = convo
-					c.mu.Unlock()
+			if ans.text != "" {
+				if firstChunk == 0 {
+					firstChunk = time.Since(start)
+					firstChunkSpan.End()
+				}
+				text := strings.TrimPrefix(ans.text, prefix)
 
-					r <- ChatResponse{Message: res.Message.Content.Parts[0]}
+				if !account.IsAPI() {
+					convo.ID = ans.meta.ConversationID
 				}
+				convo.LastMessageID = ans.meta.MessageID
+				convo.LastAnswer = prefix + text
+				c.saveConversation(tgChatID, convo)
+
+				last = ChatResponse{Type: ResponseDelta, Message: text, Metadata: ans.meta}
+				r <- last
 			}
 		}
+
+		if last.Message == "" {
+			if done {
+				err = errors.New("ChatGPT returned an empty response")
+			} else {
+				err = errors.New("The connection to ChatGPT was lost before it answered")
+			}
+			account.reportError(&UpstreamError{Kind: ErrorStream, Err: err})
+			r <- ChatResponse{Type: ResponseError, Err: err}
+			return
+		}
+
+		if account.IsAPI() {
+			c.mu.Lock()
+			imageHistory := c.imageHistory
+			c.mu.Unlock()
+
+			convo.recordHistory(p, imageHistory)
+			c.saveConversation(tgChatID, convo)
+		}
+
+		last.Type = ResponseFinal
+		last.Interrupted = !done
+		metrics.ObserveAnswer(account.Name, firstChunk, time.Since(start))
+		span.SetAttributes(
+			attribute.String("chatgpt.conversation_id", convo.ID),
+			attribute.String("chatgpt.message_id", convo.LastMessageID),
+			attribute.Bool("chatgpt.interrupted", last.Interrupted),
+		)
+		log.With(
+			"conversation_id", convo.ID,
+			"message_id", convo.LastMessageID,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"first_chunk_ms", firstChunk.Milliseconds(),
+			"interrupted", last.Interrupted,
+			"answer", logger.Content(convo.LastAnswer),
+		).Infof("Answered")
+		r <- last
 	}()
 
 	return r, nil
 }
 
-func (c *ChatGPT) refreshAccessToken() (string, error) {
-	cachedAccessToken, ok := c.AccessTokenMap.Get(KEY_ACCESS_TOKEN)
-	if ok {
-		return cachedAccessToken, nil
+func (c *ChatGPT) saveConversation(tgChatID int64, convo Conversation) {
+	c.mu.Lock() // lock the map to avoid data racing
+	defer c.mu.Unlock()
+
+	c.conversations[tgChatID] = convo
+}
+
+// connect opens the event stream on the conversation's account, moving it to another one when it's
+// unavailable, and retrying transient failures according to c.RetryPolicy. notify gets a ResponseRetry before every
+// new attempt, and a ResponseNotice when moving the conversation loses its context.
+func (c *ChatGPT) connect(ctx context.Context, log *logger.Logger, convo Conversation, p prompt, notify func(ChatResponse)) (*sse.Client, *Account, Conversation, error) {
+	onRetry := func(attempt int, err error) {
+		notify(ChatResponse{Type: ResponseRetry, Err: err, Attempt: attempt, MaxAttempts: c.RetryPolicy.MaxAttempts})
 	}
+	// a website account drops its access token when it's rejected, so it gets one more try with a new one
+	retriedAuth := false
 
-	req, err := http.NewRequest("GET", "https://chat.openai.com/api/auth/session", nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+	for attempt := 1; ; attempt++ {
+		account := c.pickAccount(convo.Account, len(p.images) > 0 || p.oneOff)
+		if account == nil && p.oneOff && len(p.images) == 0 {
+			account = c.pickAccount(convo.Account, false)
+		}
+		if account == nil && len(p.images) > 0 {
+			return nil, nil, convo, errors.New("No ChatGPT account that accepts images is available right now")
+		}
+		if account == nil {
+			return nil, nil, convo, errors.New("No ChatGPT account is available right now")
+		}
+
+		if account.Name != convo.Account {
+			previous := c.account(convo.Account)
+			if previous != nil {
+				if p.continuation && !(account.IsAPI() && previous.IsAPI()) {
+					return nil, nil, convo, errors.New(fmt.Sprintf("Account %s, where this answer was written, is unavailable", previous.Name))
+				}
+				log.Infof("Moving conversation from account %s to %s", previous.Name, account.Name)
+			}
+			moved := convo.moveTo(account, previous)
+			if convo.ID != "" || len(convo.History) > len(moved.History) {
+				log.Warnf("Conversation context was lost moving from account %s to %s", convo.Account, account.Name)
+				notify(ChatResponse{Type: ResponseNotice, Message: fmt.Sprintf("Account %s is unavailable, so this conversation continues in %s without its earlier messages.", convo.Account, account.Name)})
+			}
+			convo = moved
+		}
+
+		req, err := c.newRequest(ctx, account, convo, p)
+		if err != nil {
+			if attempt >= c.RetryPolicy.MaxAttempts {
+				return nil, nil, convo, err
+			}
+			onRetry(attempt+1, err)
+
+			// the account was taken out of the pool if it can't authenticate, otherwise give it another try
+			if !account.available() {
+				log.With("account", account.Name).Warnf("Couldn't prepare request (%v), trying another account (%d/%d)", err, attempt+1, c.RetryPolicy.MaxAttempts)
+				continue
+			}
+
+			delay := c.RetryPolicy.Backoff(attempt)
+			log.With("account", account.Name).Warnf("Couldn't prepare request (%v), retrying in %v (%d/%d)", err, delay, attempt+1, c.RetryPolicy.MaxAttempts)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, nil, convo, ctx.Err()
+			}
+			continue
+		}
+
+		client := sse.Init(req.URL.String())
+		err = client.ConnectRequest(req)
+		if err == nil {
+			account.reportSuccess()
+			return &client, account, convo, nil
+		}
+
+		upstreamErr := classifyError(err)
+		account.reportError(upstreamErr)
+		retryAuth := upstreamErr.Kind == ErrorUnauthorized && !account.IsAPI() && !retriedAuth
+		if attempt >= c.RetryPolicy.MaxAttempts || (!upstreamErr.Retryable() && !retryAuth && account.available()) {
+			return nil, nil, convo, upstreamErr
+		}
+
+		onRetry(attempt+1, upstreamErr)
+
+		if !account.available() {
+			log.With("account", account.Name).Warnf("Account failed (%v), trying another one (%d/%d)", upstreamErr, attempt+1, c.RetryPolicy.MaxAttempts)
+			continue
+		}
+		if retryAuth {
+			retriedAuth = true
+			log.With("account", account.Name).Warnf("Access token was rejected (%v), retrying with a new one (%d/%d)", upstreamErr, attempt+1, c.RetryPolicy.MaxAttempts)
+			continue
+		}
+
+		delay := c.RetryPolicy.Backoff(attempt)
+		if upstreamErr.RetryAfter > delay && upstreamErr.RetryAfter <= c.RetryPolicy.MaxDelay {
+			delay = upstreamErr.RetryAfter
+		}
+		log.With("account", account.Name).Warnf("ChatGPT request failed (%v), retrying in %v (%d/%d)", upstreamErr, delay, attempt+1, c.RetryPolicy.MaxAttempts)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, nil, convo, ctx.Err()
+		}
 	}
+}
 
-	req.Header.Set("User-Agent", USER_AGENT)
-	req.Header.Set("Cookie", fmt.Sprintf("__Secure-next-auth.session-token=%s", c.SessionToken))
+// newRequest builds the streaming request that sends p to the account
+func (c *ChatGPT) newRequest(ctx context.Context, account *Account, convo Conversation, p prompt) (*http.Request, error) {
+	var url, authorization string
+	var payload interface{}
+	model := c.modelFor(account)
+
+	if account.IsAPI() {
+		url = strings.TrimSuffix(account.BaseURL, "/") + "/chat/completions"
+		authorization = account.APIKey
+		payload = newCompletionRequest(model, convo, p)
+	} else {
+		accessToken, err := c.refreshAccessToken(ctx, account)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Couldn't get access token: %v", err))
+		}
 
-	res, err := http.DefaultClient.Do(req)
+		url = "https://chat.openai.com/backend-api/conversation"
+		authorization = accessToken
+		if p.continuation {
+			payload = newContinueRequest(model, convo)
+		} else {
+			payload = newMessageRequest(p.text, model, convo)
+		}
+	}
+
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to perform request: %v", err)
+		return nil, errors.New(fmt.Sprintf("Couldn't encode message: %v", err))
 	}
-	defer res.Body.Close()
 
-	var result SessionResult
-	err = json.NewDecoder(res.Body).Decode(&result)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+		return nil, errors.New(fmt.Sprintf("Couldn't create request: %v", err))
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", USER_AGENT)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authorization))
 
-	accessToken := result.AccessToken
-	if accessToken == "" {
-		return "", errors.New("unauthorized")
+	return req, nil
+}
+
+// get sends an authenticated GET request to the account's backend, decoding the JSON response into v
+func (c *ChatGPT) get(ctx context.Context, account *Account, url string, v interface{}) error {
+	return c.do(ctx, account, "GET", url, nil, v)
+}
+
+// do sends an authenticated request to the account's backend, with payload encoded as JSON if it's not nil,
+// and decodes the JSON response into v if it's not nil
+func (c *ChatGPT) do(ctx context.Context, account *Account, method string, url string, payload interface{}, v interface{}) error {
+	authorization := account.APIKey
+	if !account.IsAPI() {
+		accessToken, err := c.refreshAccessToken(ctx, account)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Couldn't get access token: %v", err))
+		}
+		authorization = accessToken
 	}
 
-	if result.Error != "" {
-		if result.Error == "RefreshAccessTokenError" {
-			return "", errors.New("Session token has expired")
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Couldn't encode request: %v", err))
 		}
+		body = bytes.NewReader(encoded)
+	}
 
-		return "", errors.New(result.Error)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't create request: %v", err))
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authorization))
 
-	expiryTime, err := time.Parse(time.RFC3339, result.Expires)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse expiry time: %v", err)
+		return classifyError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return classifyError(&sse.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: body})
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.New(fmt.Sprintf("Couldn't decode response: %v", err))
 	}
-	c.AccessTokenMap.Set(KEY_ACCESS_TOKEN, accessToken, expiryTime.Sub(time.Now()))
+	return nil
+}
 
-	return accessToken, nil
+func (a *Account) parseEvent(data string, ans *answer) error {
+	if a.IsAPI() {
+		return parseCompletionChunk(data, ans)
+	}
+	return parseMessageResponse(data, ans)
 }
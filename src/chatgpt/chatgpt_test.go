@@ -0,0 +1,141 @@
+package chatgpt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/config"
+	"github.com/m1guelpf/chatgpt-telegram/src/retry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendMessage(t *testing.T) {
+	chunk := func(content string, finishReason string) string {
+		return fmt.Sprintf(`data: {"id": "1", "model": "gpt-4", "choices": [{"delta": {"content": %q}, "finish_reason": %q}]}`+"\n\n", content, finishReason)
+	}
+
+	for label, test := range map[string]struct {
+		status    int
+		body      string
+		wantTypes []ResponseType
+		// want is the last response, Err being compared by its message
+		want    ChatResponse
+		wantErr string
+	}{
+		"answer": {
+			body:      chunk("Hel", "") + chunk("lo", "stop") + "data: [DONE]\n\n",
+			wantTypes: []ResponseType{ResponseDelta, ResponseDelta, ResponseFinal},
+			want:      ChatResponse{Type: ResponseFinal, Message: "Hello", Metadata: Metadata{Model: "gpt-4", FinishReason: "stop", MessageID: "1"}},
+		},
+		"stream ends early": {
+			body:      chunk("Hel", ""),
+			wantTypes: []ResponseType{ResponseDelta, ResponseFinal},
+			want:      ChatResponse{Type: ResponseFinal, Message: "Hel", Metadata: Metadata{Model: "gpt-4", MessageID: "1"}, Interrupted: true},
+		},
+		"error in the middle of the answer": {
+			body:      chunk("Hel", "") + `data: {"error": {"message": "Overloaded"}}` + "\n\n",
+			wantTypes: []ResponseType{ResponseDelta, ResponseError},
+			wantErr:   "Overloaded",
+		},
+		"empty answer": {
+			body:      "data: [DONE]\n\n",
+			wantTypes: []ResponseType{ResponseError},
+			wantErr:   "ChatGPT returned an empty response",
+		},
+		"refused request": {
+			status:    http.StatusBadRequest,
+			body:      `{"error": {"message": "Bad prompt"}}`,
+			wantTypes: []ResponseType{ResponseError},
+			wantErr:   "Couldn't connect to ChatGPT: Bad prompt (400 Bad Request)",
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.status != 0 {
+					w.WriteHeader(test.status)
+				}
+				fmt.Fprint(w, test.body)
+			}))
+			t.Cleanup(server.Close)
+
+			c := Init([]config.Account{{Name: "api", APIKey: "key", BaseURL: server.URL}})
+			c.RetryPolicy = retry.Policy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+			feed, err := c.SendMessage(context.Background(), "Hi", 1)
+			require.NoError(t, err)
+
+			var types []ResponseType
+			var last ChatResponse
+			for response := range feed {
+				types = append(types, response.Type)
+				last = response
+			}
+
+			require.Equal(t, test.wantTypes, types)
+			if test.wantErr != "" {
+				require.EqualError(t, last.Err, test.wantErr)
+				return
+			}
+			require.Equal(t, test.want, last)
+		})
+	}
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	for label, test := range map[string]struct {
+		status       int
+		wantRequests int32
+		wantTypes    []ResponseType
+	}{
+		"unavailable": {
+			status:       http.StatusServiceUnavailable,
+			wantRequests: 3,
+			wantTypes:    []ResponseType{ResponseRetry, ResponseRetry, ResponseDelta, ResponseFinal},
+		},
+		"bad request": {
+			status:       http.StatusBadRequest,
+			wantRequests: 1,
+			wantTypes:    []ResponseType{ResponseError},
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			// the first two requests fail with status
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= 2 {
+					w.WriteHeader(test.status)
+					fmt.Fprint(w, `{"error": {"message": "Try again"}}`)
+					return
+				}
+				fmt.Fprint(w, "data: {\"id\": \"1\", \"choices\": [{\"delta\": {\"content\": \"Hello\"}}]}\n\ndata: [DONE]\n\n")
+			}))
+			t.Cleanup(server.Close)
+
+			c := Init([]config.Account{{Name: "api", APIKey: "key", BaseURL: server.URL}})
+			c.RetryPolicy = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+			feed, err := c.SendMessage(context.Background(), "Hi", 1)
+			require.NoError(t, err)
+
+			var types []ResponseType
+			var attempts []int
+			for response := range feed {
+				types = append(types, response.Type)
+				if response.Type == ResponseRetry {
+					attempts = append(attempts, response.Attempt)
+				}
+			}
+
+			require.Equal(t, test.wantTypes, types)
+			require.Equal(t, test.wantRequests, atomic.LoadInt32(&requests))
+			if len(attempts) > 0 {
+				require.Equal(t, []int{2, 3}, attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/sse"
+)
+
+type ErrorKind string
+
+const (
+	ErrorRateLimited  ErrorKind = "rate_limited"
+	ErrorUnavailable  ErrorKind = "unavailable"
+	ErrorChallenge    ErrorKind = "challenge"
+	ErrorUnauthorized ErrorKind = "unauthorized"
+	ErrorNetwork      ErrorKind = "network"
+	ErrorStream       ErrorKind = "stream" // the answer failed after it started streaming
+	ErrorOther        ErrorKind = "other"
+)
+
+// UpstreamError is returned when the backend couldn't be reached or refused the request.
+type UpstreamError struct {
+	Kind       ErrorKind
+	StatusCode int
+	Message    string
+	// RetryAfter is the delay requested by the backend, if any
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return e.Err.Error()
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the request might succeed if attempted again.
+func (e *UpstreamError) Retryable() bool {
+	switch e.Kind {
+	case ErrorRateLimited, ErrorUnavailable, ErrorChallenge, ErrorNetwork:
+		return true
+	default:
+		return false
+	}
+}
+
+// classifyError turns a failed connection into an UpstreamError,
+// using the "detail" message returned by the backend when there's one.
+func classifyError(err error) *UpstreamError {
+	var statusErr *sse.StatusError
+	if !errors.As(err, &statusErr) {
+		return &UpstreamError{Kind: ErrorNetwork, Err: err}
+	}
+
+	upstreamErr := &UpstreamError{
+		Kind:       ErrorOther,
+		StatusCode: statusErr.StatusCode,
+		Err:        err,
+	}
+
+	body := string(statusErr.Body)
+	switch {
+	case statusErr.Header.Get("cf-mitigated") == "challenge" || strings.Contains(body, "cf-chl") || strings.Contains(body, "Just a moment..."):
+		upstreamErr.Kind = ErrorChallenge
+		upstreamErr.Message = fmt.Sprintf("Blocked by a Cloudflare challenge (%s)", statusErr.Status)
+		return upstreamErr
+	case statusErr.StatusCode == http.StatusTooManyRequests:
+		upstreamErr.Kind = ErrorRateLimited
+	case statusErr.StatusCode == http.StatusUnauthorized:
+		upstreamErr.Kind = ErrorUnauthorized
+	case statusErr.StatusCode == http.StatusBadGateway || statusErr.StatusCode == http.StatusServiceUnavailable || statusErr.StatusCode == http.StatusGatewayTimeout || statusErr.StatusCode == http.StatusInternalServerError:
+		upstreamErr.Kind = ErrorUnavailable
+	}
+
+	if seconds, err := strconv.Atoi(statusErr.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		upstreamErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	// the website returns {"detail": "..."}, while the API returns {"error": {"message": "..."}}
+	var res struct {
+		Detail interface{} `json:"detail"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(statusErr.Body, &res) == nil {
+		switch detail := res.Detail.(type) {
+		case string:
+			upstreamErr.Message = fmt.Sprintf("%s (%s)", detail, statusErr.Status)
+		case map[string]interface{}:
+			if message, ok := detail["message"].(string); ok {
+				upstreamErr.Message = fmt.Sprintf("%s (%s)", message, statusErr.Status)
+			}
+		}
+		if res.Error.Message != "" {
+			upstreamErr.Message = fmt.Sprintf("%s (%s)", res.Error.Message, statusErr.Status)
+		}
+	}
+
+	return upstreamErr
+}
@@ -0,0 +1,66 @@
+package chatgpt
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/sse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	for label, test := range map[string]struct {
+		err        error
+		kind       ErrorKind
+		message    string
+		retryAfter time.Duration
+		retryable  bool
+	}{
+		"network error": {
+			err:       errors.New("connection refused"),
+			kind:      ErrorNetwork,
+			message:   "connection refused",
+			retryable: true,
+		},
+		"rate limited with Retry-After": {
+			err:        &sse.StatusError{StatusCode: 429, Status: "429 Too Many Requests", Header: http.Header{"Retry-After": {"7"}}},
+			kind:       ErrorRateLimited,
+			message:    "failed to connect to SSE: 429 Too Many Requests",
+			retryAfter: 7 * time.Second,
+			retryable:  true,
+		},
+		"cloudflare challenge": {
+			err:       &sse.StatusError{StatusCode: 403, Status: "403 Forbidden", Header: http.Header{}, Body: []byte("<title>Just a moment...</title>")},
+			kind:      ErrorChallenge,
+			message:   "Blocked by a Cloudflare challenge (403 Forbidden)",
+			retryable: true,
+		},
+		"unauthorized with website detail": {
+			err:     &sse.StatusError{StatusCode: 401, Status: "401 Unauthorized", Header: http.Header{}, Body: []byte(`{"detail": "Token expired"}`)},
+			kind:    ErrorUnauthorized,
+			message: "Token expired (401 Unauthorized)",
+		},
+		"unavailable with website detail object": {
+			err:       &sse.StatusError{StatusCode: 503, Status: "503 Service Unavailable", Header: http.Header{}, Body: []byte(`{"detail": {"message": "At capacity"}}`)},
+			kind:      ErrorUnavailable,
+			message:   "At capacity (503 Service Unavailable)",
+			retryable: true,
+		},
+		"bad request with API error": {
+			err:     &sse.StatusError{StatusCode: 400, Status: "400 Bad Request", Header: http.Header{}, Body: []byte(`{"error": {"message": "Invalid model"}}`)},
+			kind:    ErrorOther,
+			message: "Invalid model (400 Bad Request)",
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			upstreamErr := classifyError(test.err)
+			require.Equal(t, test.kind, upstreamErr.Kind)
+			require.Equal(t, test.message, upstreamErr.Error())
+			require.Equal(t, test.retryAfter, upstreamErr.RetryAfter)
+			require.Equal(t, test.retryable, upstreamErr.Retryable())
+			require.ErrorIs(t, upstreamErr, test.err)
+		})
+	}
+}
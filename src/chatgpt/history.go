@@ -0,0 +1,128 @@
+package chatgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// historyPageSize is how many conversations are listed per request
+const historyPageSize = 50
+
+// ExportedConversation is a conversation stored in a website account
+type ExportedConversation struct {
+	Account  string            `json:"account"`
+	ID       string            `json:"id"`
+	Title    string            `json:"title"`
+	Created  time.Time         `json:"created"`
+	Messages []ExportedMessage `json:"messages"`
+}
+
+type ExportedMessage struct {
+	Role    string    `json:"role"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+type conversationList struct {
+	Items []struct {
+		ID string `json:"id"`
+	} `json:"items"`
+	Total int `json:"total"`
+}
+
+type conversationNode struct {
+	Parent  string `json:"parent"`
+	Message *struct {
+		Author struct {
+			Role string `json:"role"`
+		} `json:"author"`
+		Content struct {
+			Parts []string `json:"parts"`
+		} `json:"content"`
+		CreateTime float64 `json:"create_time"`
+	} `json:"message"`
+}
+
+type conversationDetail struct {
+	Title       string                      `json:"title"`
+	CreateTime  float64                     `json:"create_time"`
+	Mapping     map[string]conversationNode `json:"mapping"`
+	CurrentNode string                      `json:"current_node"`
+}
+
+// ExportHistory fetches every conversation stored in a website account, calling export for each of them.
+// API accounts have no history to export, since their conversations only live in memory.
+func (c *ChatGPT) ExportHistory(ctx context.Context, accountName string, export func(ExportedConversation) error) error {
+	account := c.account(accountName)
+	if account == nil {
+		return errors.New(fmt.Sprintf("Unknown account %s", accountName))
+	}
+	if account.IsAPI() {
+		return errors.New(fmt.Sprintf("Account %s uses an API key, its conversations aren't stored", accountName))
+	}
+
+	for offset := 0; ; offset += historyPageSize {
+		var list conversationList
+		listURL := fmt.Sprintf("https://chat.openai.com/backend-api/conversations?offset=%d&limit=%d", offset, historyPageSize)
+		if err := c.get(ctx, account, listURL, &list); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't list conversations: %v", err))
+		}
+
+		for _, item := range list.Items {
+			var detail conversationDetail
+			if err := c.get(ctx, account, "https://chat.openai.com/backend-api/conversation/"+url.PathEscape(item.ID), &detail); err != nil {
+				return errors.New(fmt.Sprintf("Couldn't get conversation %s: %v", item.ID, err))
+			}
+
+			if err := export(detail.export(account.Name, item.ID)); err != nil {
+				return err
+			}
+		}
+
+		if len(list.Items) == 0 || offset+len(list.Items) >= list.Total {
+			return nil
+		}
+	}
+}
+
+// export returns the messages leading to the current node, skipping the empty system ones
+func (d conversationDetail) export(account string, id string) ExportedConversation {
+	convo := ExportedConversation{
+		Account: account,
+		ID:      id,
+		Title:   d.Title,
+		Created: unixTime(d.CreateTime),
+	}
+
+	for nodeID := d.CurrentNode; nodeID != ""; nodeID = d.Mapping[nodeID].Parent {
+		message := d.Mapping[nodeID].Message
+		if message == nil {
+			continue
+		}
+
+		text := strings.Join(message.Content.Parts, "\n")
+		if text == "" {
+			continue
+		}
+		convo.Messages = append([]ExportedMessage{{
+			Role:    message.Author.Role,
+			Text:    text,
+			Created: unixTime(message.CreateTime),
+		}}, convo.Messages...)
+	}
+
+	return convo
+}
+
+func unixTime(seconds float64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)).UTC()
+}
@@ -0,0 +1,60 @@
+package chatgpt
+
+import "github.com/google/uuid"
+
+const DEFAULT_MODEL = "text-davinci-002-render"
+
+type MessageContent struct {
+	ContentType string   `json:"content_type"`
+	Parts       []string `json:"parts"`
+}
+
+type RequestMessage struct {
+	ID      string         `json:"id"`
+	Role    string         `json:"role"`
+	Content MessageContent `json:"content"`
+}
+
+// MessageRequest is the body sent to the conversation endpoint.
+type MessageRequest struct {
+	Action          string           `json:"action"`
+	Messages        []RequestMessage `json:"messages,omitempty"`
+	Model           string           `json:"model"`
+	ParentMessageID string           `json:"parent_message_id"`
+	// if conversation id is empty, a new conversation is started
+	ConversationID string `json:"conversation_id,omitempty"`
+}
+
+func newMessageRequest(message string, model string, convo Conversation) MessageRequest {
+	parentMessageID := convo.LastMessageID
+	if parentMessageID == "" {
+		parentMessageID = uuid.NewString()
+	}
+
+	return MessageRequest{
+		Action: "next",
+		Messages: []RequestMessage{
+			{
+				ID:   uuid.NewString(),
+				Role: "user",
+				Content: MessageContent{
+					ContentType: "text",
+					Parts:       []string{message},
+				},
+			},
+		},
+		Model:           model,
+		ParentMessageID: parentMessageID,
+		ConversationID:  convo.ID,
+	}
+}
+
+// newContinueRequest asks ChatGPT to keep writing the last answer of convo
+func newContinueRequest(model string, convo Conversation) MessageRequest {
+	return MessageRequest{
+		Action:          "continue",
+		Model:           model,
+		ParentMessageID: convo.LastMessageID,
+		ConversationID:  convo.ID,
+	}
+}
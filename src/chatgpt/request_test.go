@@ -0,0 +1,45 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRequestRoundTrip(t *testing.T) {
+	for label, text := range map[string]string{
+		"quotes":      `She said "hi" and left`,
+		"backslashes": `C:\Users\me and \n not a newline \"`,
+		"newlines":    "first line\nsecond line\r\n\ttabbed",
+		"json":        `{"action": "variant", "model": "other"}`,
+		"unicode":     "héllo 👋 \u2028 <tag> & more",
+	} {
+		t.Run(label, func(t *testing.T) {
+			convo := Conversation{ID: "convo", LastMessageID: "parent"}
+			encoded, err := json.Marshal(newMessageRequest(text, "model", convo))
+			require.NoError(t, err)
+
+			var decoded MessageRequest
+			require.NoError(t, json.Unmarshal(encoded, &decoded))
+			require.Equal(t, "next", decoded.Action)
+			require.Equal(t, "model", decoded.Model)
+			require.Equal(t, "parent", decoded.ParentMessageID)
+			require.Equal(t, "convo", decoded.ConversationID)
+			require.Len(t, decoded.Messages, 1)
+			require.Equal(t, "user", decoded.Messages[0].Role)
+			require.Equal(t, MessageContent{ContentType: "text", Parts: []string{text}}, decoded.Messages[0].Content)
+		})
+	}
+}
+
+func TestNewConversationRequest(t *testing.T) {
+	encoded, err := json.Marshal(newMessageRequest("Hi", "model", Conversation{}))
+	require.NoError(t, err)
+
+	// a new conversation has no id, and gets a random parent message
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(encoded, &fields))
+	require.NotContains(t, fields, "conversation_id")
+	require.NotEmpty(t, fields["parent_message_id"])
+}
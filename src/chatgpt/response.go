@@ -0,0 +1,96 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type ResponseType int
+
+const (
+	// ResponseDelta carries the answer generated so far
+	ResponseDelta ResponseType = iota
+	// ResponseFinal is sent once, after the stream ends, with the complete answer
+	ResponseFinal
+	// ResponseError is sent when the request fails, and is always the last response
+	ResponseError
+	// ResponseRetry is sent before retrying a request that failed with a transient error
+	ResponseRetry
+	// ResponseNotice carries a note for the user that isn't part of the answer, like the conversation losing its context
+	ResponseNotice
+)
+
+type Metadata struct {
+	Model          string
+	FinishReason   string
+	ConversationID string
+	MessageID      string
+}
+
+type ChatResponse struct {
+	Type     ResponseType
+	Message  string
+	Metadata Metadata
+	Err      error
+	// Interrupted is set on ResponseFinal when the stream ended before ChatGPT finished answering
+	Interrupted bool
+
+	// Attempt and MaxAttempts are set on ResponseRetry responses
+	Attempt     int
+	MaxAttempts int
+}
+
+type MessageResponse struct {
+	ConversationId string `json:"conversation_id"`
+	Error          string `json:"error"`
+	Message        struct {
+		ID       string         `json:"id"`
+		Content  MessageContent `json:"content"`
+		Metadata struct {
+			ModelSlug     string `json:"model_slug"`
+			FinishDetails struct {
+				Type string `json:"type"`
+			} `json:"finish_details"`
+		} `json:"metadata"`
+	} `json:"message"`
+}
+
+func (res MessageResponse) metadata() Metadata {
+	return Metadata{
+		Model:          res.Message.Metadata.ModelSlug,
+		FinishReason:   res.Message.Metadata.FinishDetails.Type,
+		ConversationID: res.ConversationId,
+		MessageID:      res.Message.ID,
+	}
+}
+
+// Truncated reports whether the answer was cut off by the model's length limit
+func (r ChatResponse) Truncated() bool {
+	return r.Metadata.FinishReason == "max_tokens" || r.Metadata.FinishReason == "length"
+}
+
+// answer accumulates a streamed answer
+type answer struct {
+	text string
+	meta Metadata
+}
+
+// parseMessageResponse replaces the answer with the one in a website stream event, which always contains the full text
+func parseMessageResponse(data string, ans *answer) error {
+	var res MessageResponse
+	if err := json.Unmarshal([]byte(data), &res); err != nil {
+		return fmt.Errorf("Couldn't decode message response: %v", err)
+	}
+
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+
+	if len(res.Message.Content.Parts) > 0 {
+		ans.text = res.Message.Content.Parts[0]
+		ans.meta = res.metadata()
+	}
+
+	return nil
+}
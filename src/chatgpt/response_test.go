@@ -0,0 +1,27 @@
+package chatgpt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessageResponse(t *testing.T) {
+	event := func(text string) string {
+		return `{"conversation_id": "c1", "message": {"id": "m1", "content": {"content_type": "text", "parts": ["` + text + `"]}, "metadata": {"model_slug": "text-davinci-002", "finish_details": {"type": "stop"}}}}`
+	}
+
+	// every event has the full answer so far
+	var ans answer
+	require.NoError(t, parseMessageResponse(event("Hel"), &ans))
+	require.NoError(t, parseMessageResponse(event("Hello"), &ans))
+	require.Equal(t, answer{text: "Hello", meta: Metadata{Model: "text-davinci-002", FinishReason: "stop", ConversationID: "c1", MessageID: "m1"}}, ans)
+	require.True(t, ChatResponse{Metadata: Metadata{FinishReason: "max_tokens"}}.Truncated())
+
+	// events without parts leave the answer as it is
+	require.NoError(t, parseMessageResponse(`{"conversation_id": "c1", "message": {"id": "m1", "content": {"parts": []}}}`, &ans))
+	require.Equal(t, "Hello", ans.text)
+
+	require.EqualError(t, parseMessageResponse(`{"error": "Too many requests"}`, &ans), "Too many requests")
+	require.Error(t, parseMessageResponse(`not json`, &ans))
+}
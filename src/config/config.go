@@ -4,19 +4,46 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/spf13/viper"
 )
 
+// DefaultAccount is the name of the account using OpenAISession
+const DefaultAccount = "default"
+
+// Account is a ChatGPT account, authenticated either with a web session token or an API key.
+type Account struct {
+	Name         string `json:"name" yaml:"name"`
+	SessionToken string `json:"sessiontoken,omitempty" yaml:"sessiontoken,omitempty"`
+	// APIKey is used to talk to an OpenAI-compatible API at BaseURL instead of the ChatGPT website
+	APIKey  string `json:"apikey,omitempty" yaml:"apikey,omitempty"`
+	BaseURL string `json:"baseurl,omitempty" yaml:"baseurl,omitempty"`
+	Model   string `json:"model,omitempty" yaml:"model,omitempty"`
+}
+
+// Config is the state the bot keeps between runs. It's changed from the update loop and from background
+// session renewals, so its methods are safe for concurrent use. The fields must only be read directly before
+// the bot starts.
 type Config struct {
-	v *viper.Viper
+	// mu guards the fields and v, which isn't safe for concurrent use
+	mu sync.Mutex
+	v  *viper.Viper
+	// key encrypts secrets at rest, nil if they're stored in plaintext
+	key []byte
 
 	OpenAISession string
+	TelegramToken string
+	Accounts      []Account
+	// VoiceChats are the chats that get answers read out loud
+	VoiceChats []int64
 }
 
 // LoadOrCreatePersistentConfig uses the default config directory for the current OS
-// to load or create a config file named "chatgpt.json"
-func LoadOrCreatePersistentConfig() (*Config, error) {
+// to load or create a config file named "chatgpt.json".
+// If an encryption key is given, secrets are decrypted on load and encrypted when saved,
+// and any secret still stored in plaintext is encrypted right away.
+func LoadOrCreatePersistentConfig(key []byte) (*Config, error) {
 	configPath, err := os.UserConfigDir()
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Couldn't get user config dir: %v", err))
@@ -42,13 +69,172 @@ func LoadOrCreatePersistentConfig() (*Config, error) {
 		return nil, errors.New(fmt.Sprintf("Error parsing config: %v", err))
 	}
 	cfg.v = v
+	cfg.key = key
+
+	var plaintext bool
+	for _, secret := range cfg.secrets() {
+		if *secret != "" && !isEncrypted(*secret) {
+			plaintext = true
+		}
+		if *secret, err = decrypt(key, *secret); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error decrypting config: %v", err))
+		}
+	}
+
+	if plaintext && key != nil {
+		cfg.mu.Lock()
+		err := cfg.save()
+		cfg.mu.Unlock()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Couldn't encrypt config: %v", err))
+		}
+	}
 
 	return &cfg, nil
 }
 
+// secrets returns the fields that are encrypted at rest
+func (cfg *Config) secrets() []*string {
+	secrets := []*string{&cfg.OpenAISession, &cfg.TelegramToken}
+	for i := range cfg.Accounts {
+		secrets = append(secrets, &cfg.Accounts[i].SessionToken, &cfg.Accounts[i].APIKey)
+	}
+	return secrets
+}
+
+// save writes the config to disk, encrypting secrets if there's a key. The caller must hold cfg.mu.
+func (cfg *Config) save() error {
+	encrypted := &Config{
+		OpenAISession: cfg.OpenAISession,
+		TelegramToken: cfg.TelegramToken,
+		Accounts:      append([]Account(nil), cfg.Accounts...),
+	}
+
+	if cfg.key != nil {
+		for _, secret := range encrypted.secrets() {
+			var err error
+			if *secret, err = encrypt(cfg.key, *secret); err != nil {
+				return errors.New(fmt.Sprintf("Couldn't encrypt secret: %v", err))
+			}
+		}
+	}
+
+	// keys must match the struct field names
+	cfg.v.Set("OpenAISession", encrypted.OpenAISession)
+	if encrypted.TelegramToken != "" {
+		cfg.v.Set("TelegramToken", encrypted.TelegramToken)
+	}
+	if len(encrypted.Accounts) > 0 {
+		cfg.v.Set("Accounts", encrypted.Accounts)
+	}
+	if len(cfg.VoiceChats) > 0 || cfg.v.IsSet("VoiceChats") {
+		cfg.v.Set("VoiceChats", cfg.VoiceChats)
+	}
+	return cfg.v.WriteConfig()
+}
+
+// RotateKey re-encrypts every secret with a new key (or stores them in plaintext if it's nil)
+func (cfg *Config) RotateKey(key []byte) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.key = key
+	return cfg.save()
+}
+
 func (cfg *Config) SetSessionToken(token string) error {
-	// key must match the struct field name
-	cfg.v.Set("OpenAISession", token)
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
 	cfg.OpenAISession = token
-	return cfg.v.WriteConfig()
+	return cfg.save()
+}
+
+// SessionToken returns the session token of the default account
+func (cfg *Config) SessionToken() string {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	return cfg.OpenAISession
+}
+
+// StoredTelegramToken returns the bot token saved by older versions, if any
+func (cfg *Config) StoredTelegramToken() string {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	return cfg.TelegramToken
+}
+
+// AllAccounts returns the configured accounts, including the default one if OpenAISession is set
+func (cfg *Config) AllAccounts() []Account {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	accounts := make([]Account, 0, len(cfg.Accounts)+1)
+	if cfg.OpenAISession != "" {
+		accounts = append(accounts, Account{Name: DefaultAccount, SessionToken: cfg.OpenAISession})
+	}
+	return append(accounts, cfg.Accounts...)
+}
+
+// SetAccountSessionToken saves the session token of the given account, adding it if it's only defined in the config file
+func (cfg *Config) SetAccountSessionToken(name string, token string) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if name == DefaultAccount {
+		cfg.OpenAISession = token
+		return cfg.save()
+	}
+
+	for i := range cfg.Accounts {
+		if cfg.Accounts[i].Name == name {
+			cfg.Accounts[i].SessionToken = token
+			return cfg.save()
+		}
+	}
+
+	cfg.Accounts = append(cfg.Accounts, Account{Name: name, SessionToken: token})
+	return cfg.save()
+}
+
+// HasVoiceReplies reports whether answers in the chat are read out loud
+func (cfg *Config) HasVoiceReplies(chatID int64) bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	return cfg.hasVoiceReplies(chatID)
+}
+
+func (cfg *Config) hasVoiceReplies(chatID int64) bool {
+	for _, id := range cfg.VoiceChats {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// SetVoiceReplies chooses whether answers in the chat are read out loud
+func (cfg *Config) SetVoiceReplies(chatID int64, enabled bool) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if cfg.hasVoiceReplies(chatID) == enabled {
+		return nil
+	}
+
+	if enabled {
+		cfg.VoiceChats = append(cfg.VoiceChats, chatID)
+	} else {
+		chats := make([]int64, 0, len(cfg.VoiceChats))
+		for _, id := range cfg.VoiceChats {
+			if id != chatID {
+				chats = append(chats, id)
+			}
+		}
+		cfg.VoiceChats = chats
+	}
+	return cfg.save()
 }
@@ -3,8 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/require"
 )
 
@@ -61,6 +63,7 @@ EDIT_WAIT_SECONDS=`,
 			},
 			want: &EnvConfig{
 				TelegramID:      []int64{123, 456},
+				TelegramAdminID: []int64{},
 				TelegramToken:   "token",
 				EditWaitSeconds: 10,
 			},
@@ -101,6 +104,21 @@ EDIT_WAIT_SECONDS=10`,
 				EditWaitSeconds: 20,
 			},
 		},
+		"admin IDs and session settings provided in env": {
+			envVars: map[string]string{
+				"TELEGRAM_ADMIN_ID":     "789",
+				"TELEGRAM_TOKEN":        "token",
+				"OPENAI_SESSION_FILE":   "/run/secrets/openai_session",
+				"DISABLE_BROWSER_LOGIN": "true",
+			},
+			want: &EnvConfig{
+				TelegramID:          []int64{},
+				TelegramAdminID:     []int64{789},
+				TelegramToken:       "token",
+				OpenAISessionFile:   "/run/secrets/openai_session",
+				DisableBrowserLogin: true,
+			},
+		},
 		"multiple TELEGRAM_IDs provided in env": {
 			fileContent: `TELEGRAM_ID=123
 TELEGRAM_TOKEN=abc
@@ -131,3 +149,123 @@ EDIT_WAIT_SECONDS=10`,
 		})
 	}
 }
+
+func TestLoad(t *testing.T) {
+	remove, err := createFile("test.yaml", `telegram_token: file
+edit_wait_seconds: 5
+telegram_id: [1, 2]
+model: file-model
+accounts:
+  - name: work
+    apikey: sk-test
+    model: gpt-4
+`)
+	require.NoError(t, err)
+	t.Cleanup(remove)
+
+	t.Cleanup(setEnvVariables(map[string]string{
+		"EDIT_WAIT_SECONDS": "7",
+		"MODEL":             "env-model",
+	}))
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flags)
+	require.NoError(t, flags.Parse([]string{"--model", "flag-model", "--telegram-admin-id", "3,4"}))
+
+	cfg, err := Load(LoadOptions{EnvFile: "missing.env", File: "test.yaml", Flags: flags})
+	require.NoError(t, err)
+	require.Equal(t, "file", cfg.TelegramToken)
+	require.Equal(t, 7, cfg.EditWaitSeconds)
+	require.Equal(t, []int64{1, 2}, cfg.TelegramID)
+	require.Equal(t, []int64{3, 4}, cfg.TelegramAdminID)
+	require.Equal(t, "flag-model", cfg.Model)
+	require.Equal(t, []Account{{Name: "work", APIKey: "sk-test", Model: "gpt-4"}}, cfg.Accounts)
+
+	out, err := cfg.Redacted()
+	require.NoError(t, err)
+	require.Contains(t, string(out), "telegram_token: <redacted>")
+	require.NotContains(t, string(out), "sk-test")
+}
+
+func TestValidateWithDefaults(t *testing.T) {
+	cfg := &Settings{
+		TelegramAPIEndpoint: "https://example.com/bot",
+		OpenAISessionFile:   "missing-session",
+		ConfigEncryptionKey: "secret key",
+		Accounts: []Account{
+			{Name: "a", SessionToken: "token", APIKey: "key"},
+			{Name: "a"},
+			{BaseURL: "https://example.com"},
+		},
+	}
+
+	err := cfg.ValidateWithDefaults()
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.ElementsMatch(t, []string{
+		"TELEGRAM_TOKEN is not set",
+		"TELEGRAM_API_ENDPOINT must have two %s placeholders, for the token and the method",
+		"OPENAI_SESSION_FILE points to missing-session, which does not exist",
+		"CONFIG_ENCRYPTION_KEY is invalid: encryption key must be 32 random bytes encoded as base64, like the output of `openssl rand -base64 32`",
+		"account a has both a session token and an API key",
+		"account a is defined more than once",
+		"account #3 has no name",
+	}, validationErr.Problems)
+	require.Equal(t, 5, cfg.RetryAttempts)
+}
+
+func TestVoiceReplies(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	cfg, err := LoadOrCreatePersistentConfig(nil)
+	require.NoError(t, err)
+	require.False(t, cfg.HasVoiceReplies(1))
+
+	require.NoError(t, cfg.SetVoiceReplies(1, true))
+	require.NoError(t, cfg.SetVoiceReplies(2, true))
+	require.NoError(t, cfg.SetVoiceReplies(1, false))
+
+	cfg, err = LoadOrCreatePersistentConfig(nil)
+	require.NoError(t, err)
+	require.False(t, cfg.HasVoiceReplies(1))
+	require.True(t, cfg.HasVoiceReplies(2))
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	cfg, err := LoadOrCreatePersistentConfig(nil)
+	require.NoError(t, err)
+
+	// session renewals save rotated tokens while the update loop changes chat settings
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, cfg.SetAccountSessionToken("work", fmt.Sprintf("token %d", i)))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, cfg.SetVoiceReplies(int64(i), true))
+			cfg.HasVoiceReplies(int64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	cfg, err = LoadOrCreatePersistentConfig(nil)
+	require.NoError(t, err)
+	require.Len(t, cfg.VoiceChats, 10)
+	require.Len(t, cfg.Accounts, 1)
+}
+
+func TestValidImageSize(t *testing.T) {
+	require.True(t, ValidImageSize("1024x1024"))
+	require.True(t, ValidImageSize("1792x1024"))
+	require.False(t, ValidImageSize("1000x1000"))
+	require.False(t, ValidImageSize(""))
+}
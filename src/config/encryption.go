@@ -0,0 +1,117 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix marks encrypted values, so plaintext ones can still be read (and migrated)
+const encryptedPrefix = "enc:v1:"
+
+// KEY_SIZE is the size of encryption keys, which are used with AES-256
+const KEY_SIZE = 32
+
+// LoadEncryptionKey returns the key used to encrypt secrets in the persistent config, read from
+// the given value or, if it's empty, from keyFile. It returns nil if neither is set, disabling encryption.
+// Keys are KEY_SIZE random bytes encoded as base64, like the output of `openssl rand -base64 32`.
+func LoadEncryptionKey(key string, keyFile string) ([]byte, error) {
+	if key == "" && keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Couldn't read encryption key file: %v", err))
+		}
+		key = strings.TrimSpace(string(content))
+	}
+
+	if key == "" {
+		return nil, nil
+	}
+
+	return parseKey(key)
+}
+
+// parseKey decodes a key in the format returned by GenerateEncryptionKey
+func parseKey(key string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != KEY_SIZE {
+		return nil, errors.New(fmt.Sprintf("encryption key must be %d random bytes encoded as base64, like the output of `openssl rand -base64 %d`", KEY_SIZE, KEY_SIZE))
+	}
+	return decoded, nil
+}
+
+// GenerateEncryptionKey returns a new random key, in the format expected by LoadEncryptionKey
+func GenerateEncryptionKey() (string, error) {
+	key := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// encrypt seals value with AES-GCM. Empty values are left as they are.
+func encrypt(key []byte, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a value sealed by encrypt. Plaintext values are returned as they are.
+func decrypt(key []byte, value string) (string, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+	if key == nil {
+		return "", errors.New("config contains encrypted secrets, but no encryption key was provided")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("invalid encrypted value: %v", err))
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("couldn't decrypt secret, is the encryption key correct?")
+	}
+
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
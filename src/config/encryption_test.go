@@ -0,0 +1,98 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) []byte {
+	encoded, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	key, err := LoadEncryptionKey(encoded, "")
+	require.NoError(t, err)
+	require.Len(t, key, KEY_SIZE)
+	return key
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	key, err := LoadEncryptionKey("", "")
+	require.NoError(t, err)
+	require.Nil(t, key)
+
+	for _, invalid := range []string{"secret key", "c2hvcnQ=", "!!!"} {
+		_, err := LoadEncryptionKey(invalid, "")
+		require.Error(t, err, invalid)
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"), 0o600))
+	key, err = LoadEncryptionKey("", path)
+	require.NoError(t, err)
+	require.Equal(t, make([]byte, KEY_SIZE), key)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := newKey(t)
+
+	encrypted, err := encrypt(key, "token")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(encrypted, encryptedPrefix))
+
+	decrypted, err := decrypt(key, encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "token", decrypted)
+
+	_, err = decrypt(newKey(t), encrypted)
+	require.Error(t, err)
+
+	_, err = decrypt(nil, encrypted)
+	require.Error(t, err)
+
+	plaintext, err := decrypt(nil, "token")
+	require.NoError(t, err)
+	require.Equal(t, "token", plaintext)
+}
+
+func TestPersistentConfigEncryption(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	configDir, err := os.UserConfigDir()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(configDir, 0o755))
+	path := filepath.Join(configDir, "chatgpt.json")
+
+	// plaintext secrets are encrypted as soon as a key is provided
+	require.NoError(t, os.WriteFile(path, []byte(`{"openaisession": "session", "accounts": [{"name": "api", "apikey": "sk-key"}]}`), 0o600))
+
+	key := newKey(t)
+	cfg, err := LoadOrCreatePersistentConfig(key)
+	require.NoError(t, err)
+	require.Equal(t, "session", cfg.OpenAISession)
+	require.Equal(t, "sk-key", cfg.Accounts[0].APIKey)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), `"session"`)
+	require.NotContains(t, string(content), "sk-key")
+
+	require.NoError(t, cfg.SetSessionToken("new session"))
+	_, err = LoadOrCreatePersistentConfig(nil)
+	require.Error(t, err)
+
+	// after rotating, only the new key can decrypt the config
+	rotatedKey := newKey(t)
+	require.NoError(t, cfg.RotateKey(rotatedKey))
+
+	_, err = LoadOrCreatePersistentConfig(key)
+	require.Error(t, err)
+
+	cfg, err = LoadOrCreatePersistentConfig(rotatedKey)
+	require.NoError(t, err)
+	require.Equal(t, "new session", cfg.OpenAISession)
+	require.Equal(t, []Account{{Name: "api", APIKey: "sk-key"}}, cfg.Accounts)
+}
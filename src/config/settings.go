@@ -0,0 +1,505 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings is the configuration of the bot. It's loaded with Load from (lowest to highest precedence) a .env file,
+// a YAML, TOML or JSON config file, environment variables and command-line flags, and completed with MergePersistent.
+// Keys are the same everywhere, in uppercase for env variables, lowercase for config files and with dashes for flags.
+// Keys tagged with reload can be changed while the bot runs, see Watcher.
+type Settings struct {
+	TelegramID          []int64 `mapstructure:"TELEGRAM_ID" reload:"true" help:"comma-separated IDs of the Telegram users allowed to use the bot"`
+	TelegramAdminID     []int64 `mapstructure:"TELEGRAM_ADMIN_ID" reload:"true" help:"comma-separated IDs of the Telegram users allowed to manage the bot"`
+	TelegramToken       string  `mapstructure:"TELEGRAM_TOKEN" secret:"true" help:"Telegram bot token"`
+	TelegramAPIEndpoint string  `mapstructure:"TELEGRAM_API_ENDPOINT" help:"Telegram Bot API endpoint, like https://api.telegram.org/bot%s/%s"`
+	EditWaitSeconds     int     `mapstructure:"EDIT_WAIT_SECONDS" reload:"true" help:"seconds to wait between edits of a message"`
+
+	OpenAISession       string `mapstructure:"OPENAI_SESSION" secret:"true" help:"OpenAI session token of the default account"`
+	OpenAISessionFile   string `mapstructure:"OPENAI_SESSION_FILE" help:"file to read the OpenAI session token from"`
+	OpenAICookiesFile   string `mapstructure:"OPENAI_COOKIES_FILE" help:"cookies export to read the OpenAI session token from"`
+	DisableBrowserLogin bool   `mapstructure:"DISABLE_BROWSER_LOGIN" help:"never open a browser to log in to OpenAI"`
+
+	Model    string `mapstructure:"MODEL" reload:"true" help:"model of website accounts that don't set one"`
+	APIModel string `mapstructure:"API_MODEL" reload:"true" help:"model of API accounts that don't set one"`
+
+	InlineTimeoutSeconds int `mapstructure:"INLINE_TIMEOUT_SECONDS" reload:"true" help:"seconds to wait for the answer to an inline query"`
+	InlineCacheMinutes   int `mapstructure:"INLINE_CACHE_MINUTES" reload:"true" help:"minutes the answers to inline queries are reused for"`
+
+	DocumentMaxSizeMB     int `mapstructure:"DOCUMENT_MAX_SIZE_MB" reload:"true" help:"largest file that can be sent to the bot, in MB (at most 20)"`
+	DocumentMaxCharacters int `mapstructure:"DOCUMENT_MAX_CHARACTERS" reload:"true" help:"longest file text sent along with a prompt, longer files are summarized first"`
+
+	ImageHistoryMessages int `mapstructure:"IMAGE_HISTORY_MESSAGES" reload:"true" help:"how many of the latest photos sent to API accounts stay in the conversation history, 0 to only send them once"`
+
+	ConfigEncryptionKey     string `mapstructure:"CONFIG_ENCRYPTION_KEY" secret:"true" help:"key to encrypt the secrets in the persistent config"`
+	ConfigEncryptionKeyFile string `mapstructure:"CONFIG_ENCRYPTION_KEY_FILE" help:"file to read CONFIG_ENCRYPTION_KEY from"`
+	NewConfigEncryptionKey  string `mapstructure:"NEW_CONFIG_ENCRYPTION_KEY" secret:"true" help:"key the rotate-key command re-encrypts the persistent config with"`
+
+	SessionRenewHours        int `mapstructure:"SESSION_RENEW_HOURS" help:"hours between OpenAI session renewals"`
+	SessionExpiryWarningDays int `mapstructure:"SESSION_EXPIRY_WARNING_DAYS" help:"days before an OpenAI session expires to warn admins"`
+
+	RateLimitMessages      int `mapstructure:"RATE_LIMIT_MESSAGES" reload:"true" help:"prompts a user can send every RATE_LIMIT_WINDOW_SECONDS, 0 for no limit"`
+	RateLimitWindowSeconds int `mapstructure:"RATE_LIMIT_WINDOW_SECONDS" reload:"true" help:"seconds RATE_LIMIT_MESSAGES applies to"`
+
+	RetryAttempts        int `mapstructure:"RETRY_ATTEMPTS" help:"attempts for every upstream request"`
+	RetryDelaySeconds    int `mapstructure:"RETRY_DELAY_SECONDS" help:"seconds to wait before the first retry"`
+	RetryMaxDelaySeconds int `mapstructure:"RETRY_MAX_DELAY_SECONDS" help:"maximum seconds to wait between retries"`
+
+	LogLevel          string `mapstructure:"LOG_LEVEL" reload:"true" help:"minimum level of logged messages: debug, info, warn or error"`
+	LogFormat         string `mapstructure:"LOG_FORMAT" reload:"true" help:"format of logged messages: text or json"`
+	LogMessageContent bool   `mapstructure:"LOG_MESSAGE_CONTENT" reload:"true" help:"log the text of prompts and answers, instead of only their length"`
+
+	TranscriptionBackend string `mapstructure:"TRANSCRIPTION_BACKEND" help:"speech-to-text backend for voice messages: openai or whisper (disabled by default)"`
+	TranscriptionURL     string `mapstructure:"TRANSCRIPTION_URL" help:"base URL of the speech-to-text backend, like http://localhost:8080 for whisper"`
+	TranscriptionAPIKey  string `mapstructure:"TRANSCRIPTION_API_KEY" secret:"true" help:"API key of the openai speech-to-text backend"`
+	TranscriptionModel   string `mapstructure:"TRANSCRIPTION_MODEL" help:"model of the openai speech-to-text backend (whisper-1 by default)"`
+
+	TTSBackend string `mapstructure:"TTS_BACKEND" help:"text-to-speech backend for /voice replies: openai or command (disabled by default)"`
+	TTSURL     string `mapstructure:"TTS_URL" help:"base URL of the openai text-to-speech backend"`
+	TTSAPIKey  string `mapstructure:"TTS_API_KEY" secret:"true" help:"API key of the openai text-to-speech backend"`
+	TTSModel   string `mapstructure:"TTS_MODEL" help:"model of the openai text-to-speech backend (tts-1 by default)"`
+	TTSVoice   string `mapstructure:"TTS_VOICE" help:"voice of the openai text-to-speech backend (alloy by default)"`
+	TTSCommand string `mapstructure:"TTS_COMMAND" help:"shell command of the command text-to-speech backend, reading text from stdin and writing OGG/Opus audio to stdout"`
+
+	ImageBackend  string `mapstructure:"IMAGE_BACKEND" help:"image generation backend for /image: openai (disabled by default)"`
+	ImageURL      string `mapstructure:"IMAGE_URL" help:"base URL of the openai image generation backend"`
+	ImageAPIKey   string `mapstructure:"IMAGE_API_KEY" secret:"true" help:"API key of the openai image generation backend"`
+	ImageModel    string `mapstructure:"IMAGE_MODEL" help:"model of the openai image generation backend (dall-e-2 by default)"`
+	ImageSize     string `mapstructure:"IMAGE_SIZE" reload:"true" help:"default size of /image images, like 1024x1024"`
+	ImageMaxCount int    `mapstructure:"IMAGE_MAX_COUNT" reload:"true" help:"most images a single /image can ask for"`
+
+	HTTPAddress  string `mapstructure:"HTTP_ADDRESS" help:"address to serve /metrics, /healthz and /readyz on, like :9090 (disabled by default)"`
+	OTLPEndpoint string `mapstructure:"OTLP_ENDPOINT" help:"OTLP/HTTP collector to send traces to, like http://localhost:4318 (disabled by default)"`
+
+	// Accounts can only be set in the config file (or the persistent config)
+	Accounts []Account `mapstructure:"ACCOUNTS"`
+}
+
+// EnvConfig is the former name of Settings, from when they could only be set through the environment.
+//
+// Deprecated: use Settings.
+type EnvConfig = Settings
+
+// redacted replaces secrets when printing the settings
+const redacted = "<redacted>"
+
+// IMAGE_SIZES are the image sizes the OpenAI images API accepts, the first three with dall-e-2 and the last three with dall-e-3
+var IMAGE_SIZES = []string{"256x256", "512x512", "1024x1024", "1792x1024", "1024x1792"}
+
+// ValidImageSize reports whether size is one of IMAGE_SIZES
+func ValidImageSize(size string) bool {
+	for _, s := range IMAGE_SIZES {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// setting describes a key of Settings that can be set from any source
+type setting struct {
+	key    string
+	help   string
+	secret bool
+	reload bool
+	field  reflect.StructField
+}
+
+func settingKeys() []setting {
+	var keys []setting
+	t := reflect.TypeOf(Settings{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			continue
+		}
+		keys = append(keys, setting{
+			key:    field.Tag.Get("mapstructure"),
+			help:   field.Tag.Get("help"),
+			secret: field.Tag.Get("secret") == "true",
+			reload: field.Tag.Get("reload") == "true",
+			field:  field,
+		})
+	}
+	return keys
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// emptyConfig is used to initialize viper.
+// It is required to register config keys with viper when in case no config file is provided.
+func emptyConfig() string {
+	var config strings.Builder
+	for _, s := range settingKeys() {
+		config.WriteString(s.key + "=\n")
+	}
+	return config.String()
+}
+
+// RegisterFlags adds a flag for every setting, named after its key (e.g. --telegram-token for TELEGRAM_TOKEN)
+func RegisterFlags(flags *pflag.FlagSet) {
+	for _, s := range settingKeys() {
+		switch s.field.Type.Kind() {
+		case reflect.Bool:
+			flags.Bool(flagName(s.key), false, s.help)
+		case reflect.Int:
+			flags.Int(flagName(s.key), 0, s.help)
+		default:
+			flags.String(flagName(s.key), "", s.help)
+		}
+	}
+}
+
+// LoadOptions are the sources to load settings from, all of them optional
+type LoadOptions struct {
+	// EnvFile is a .env file, ignored if it doesn't exist
+	EnvFile string
+	// File is a YAML, TOML or JSON config file, its format is taken from the extension
+	File string
+	// Flags are parsed flags registered with RegisterFlags, only the ones that were set are used
+	Flags *pflag.FlagSet
+}
+
+// Load merges the settings from every source in opts and the environment.
+// Values in the environment take precedence over files, and flags over everything else.
+func Load(opts LoadOptions) (*Settings, error) {
+	v := viper.New()
+	v.SetConfigType("env")
+	v.AutomaticEnv()
+	if err := v.ReadConfig(bytes.NewBufferString(emptyConfig())); err != nil {
+		return nil, err
+	}
+	if opts.EnvFile != "" {
+		if fileExists(opts.EnvFile) {
+			v.SetConfigFile(opts.EnvFile)
+			if err := v.ReadInConfig(); err != nil {
+				return nil, err
+			}
+		} else {
+			logger.Debugf("config file %s does not exist, using env variables", opts.EnvFile)
+		}
+	}
+
+	if opts.File != "" {
+		// a separate instance, so the format is taken from the file extension
+		file := viper.New()
+		file.SetConfigFile(opts.File)
+		if err := file.ReadInConfig(); err != nil {
+			return nil, errors.New(fmt.Sprintf("Couldn't read config file: %v", err))
+		}
+		if err := v.MergeConfigMap(file.AllSettings()); err != nil {
+			return nil, errors.New(fmt.Sprintf("Couldn't merge config file: %v", err))
+		}
+	}
+
+	for _, s := range settingKeys() {
+		// keys missing from the .env file are only read from the environment once bound
+		if err := v.BindEnv(s.key); err != nil {
+			return nil, err
+		}
+		if opts.Flags == nil {
+			continue
+		}
+		if flag := opts.Flags.Lookup(flagName(s.key)); flag != nil {
+			if err := v.BindPFlag(s.key, flag); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var settings Settings
+	if err := v.Unmarshal(&settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// LoadEnvConfig loads config from .env file, variables from environment take precedence if provided.
+// If no .env file is provided, config is loaded from environment variables.
+func LoadEnvConfig(path string) (*EnvConfig, error) {
+	return Load(LoadOptions{EnvFile: path})
+}
+
+func fileExists(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return os.IsExist(err)
+	}
+	return true
+}
+
+// MergePersistent adds what's stored in the persistent config: the bot token, if it isn't set, and the accounts.
+// Accounts with the same name are merged, preferring the stored session token, since the bot keeps it up to date.
+func (e *Settings) MergePersistent(cfg *Config) {
+	if e.TelegramToken == "" {
+		e.TelegramToken = cfg.StoredTelegramToken()
+	}
+
+	for _, stored := range cfg.AllAccounts() {
+		account := e.account(stored.Name)
+		if account == nil {
+			e.Accounts = append(e.Accounts, stored)
+			continue
+		}
+
+		if stored.SessionToken != "" {
+			account.SessionToken = stored.SessionToken
+		}
+		if account.APIKey == "" {
+			account.APIKey = stored.APIKey
+		}
+		if account.BaseURL == "" {
+			account.BaseURL = stored.BaseURL
+		}
+		if account.Model == "" {
+			account.Model = stored.Model
+		}
+	}
+}
+
+func (e *Settings) account(name string) *Account {
+	for i := range e.Accounts {
+		if e.Accounts[i].Name == name {
+			return &e.Accounts[i]
+		}
+	}
+	return nil
+}
+
+func (e *Settings) HasTelegramID(id int64) bool {
+	for _, v := range e.TelegramID {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Settings) HasAdminID(id int64) bool {
+	for _, v := range e.TelegramAdminID {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowed reports whether a user can talk to the bot. Admins are always allowed.
+func (e *Settings) IsAllowed(id int64) bool {
+	return len(e.TelegramID) == 0 || e.HasTelegramID(id) || e.HasAdminID(id)
+}
+
+// ValidationError lists every problem found in the settings
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d problem(s) found:\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// ValidateWithDefaults fills in defaults for unset values, and returns a *ValidationError if the settings can't be used
+func (e *Settings) ValidateWithDefaults() error {
+	if len(e.TelegramID) == 0 {
+		logger.Warnf("TELEGRAM_ID is not set, all users will be able to use the bot")
+	}
+	e.applyDefaults()
+
+	if problems := e.problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (e *Settings) applyDefaults() {
+	if e.EditWaitSeconds < 0 {
+		logger.Infof("EDIT_WAIT_SECONDS not set, defaulting to 1")
+		e.EditWaitSeconds = 1
+	}
+	if e.RateLimitWindowSeconds <= 0 {
+		e.RateLimitWindowSeconds = 60
+	}
+	if e.SessionRenewHours <= 0 {
+		e.SessionRenewHours = 12
+	}
+	if e.SessionExpiryWarningDays <= 0 {
+		e.SessionExpiryWarningDays = 3
+	}
+	if e.RetryAttempts <= 0 {
+		e.RetryAttempts = 5
+	}
+	if e.RetryDelaySeconds <= 0 {
+		e.RetryDelaySeconds = 1
+	}
+	if e.RetryMaxDelaySeconds <= 0 {
+		e.RetryMaxDelaySeconds = 30
+	}
+	if e.RetryMaxDelaySeconds < e.RetryDelaySeconds {
+		e.RetryMaxDelaySeconds = e.RetryDelaySeconds
+	}
+	if e.InlineTimeoutSeconds <= 0 {
+		e.InlineTimeoutSeconds = 8
+	}
+	if e.InlineCacheMinutes <= 0 {
+		e.InlineCacheMinutes = 10
+	}
+	if e.DocumentMaxSizeMB <= 0 {
+		e.DocumentMaxSizeMB = 5
+	}
+	if e.DocumentMaxCharacters <= 0 {
+		e.DocumentMaxCharacters = 12000
+	}
+	if e.ImageSize == "" {
+		e.ImageSize = "1024x1024"
+	}
+	if e.ImageMaxCount <= 0 {
+		e.ImageMaxCount = 4
+	}
+}
+
+// problems returns everything that's wrong with the settings
+func (e *Settings) problems() []string {
+	var problems []string
+
+	if e.TelegramToken == "" {
+		problems = append(problems, "TELEGRAM_TOKEN is not set")
+	}
+	if e.TelegramAPIEndpoint != "" && strings.Count(e.TelegramAPIEndpoint, "%s") != 2 {
+		problems = append(problems, "TELEGRAM_API_ENDPOINT must have two %s placeholders, for the token and the method")
+	}
+	if _, err := logger.ParseLevel(e.LogLevel); err != nil {
+		problems = append(problems, "LOG_LEVEL must be debug, info, warn or error")
+	}
+	if _, err := logger.ParseFormat(e.LogFormat); err != nil {
+		problems = append(problems, "LOG_FORMAT must be text or json")
+	}
+	if e.RateLimitMessages < 0 {
+		problems = append(problems, "RATE_LIMIT_MESSAGES can't be negative")
+	}
+	if e.DocumentMaxSizeMB > 20 {
+		problems = append(problems, "DOCUMENT_MAX_SIZE_MB can't be more than 20, the most bots can download")
+	}
+	if e.ImageHistoryMessages < 0 {
+		problems = append(problems, "IMAGE_HISTORY_MESSAGES can't be negative")
+	}
+	switch e.TranscriptionBackend {
+	case "":
+	case "openai":
+		if e.TranscriptionAPIKey == "" && e.TranscriptionURL == "" {
+			problems = append(problems, "TRANSCRIPTION_API_KEY is needed by the openai transcription backend")
+		}
+	case "whisper":
+		if e.TranscriptionURL == "" {
+			problems = append(problems, "TRANSCRIPTION_URL is needed by the whisper transcription backend")
+		}
+	default:
+		problems = append(problems, "TRANSCRIPTION_BACKEND must be openai or whisper")
+	}
+	switch e.TTSBackend {
+	case "":
+	case "openai":
+		if e.TTSAPIKey == "" && e.TTSURL == "" {
+			problems = append(problems, "TTS_API_KEY is needed by the openai text-to-speech backend")
+		}
+	case "command":
+		if e.TTSCommand == "" {
+			problems = append(problems, "TTS_COMMAND is needed by the command text-to-speech backend")
+		}
+	default:
+		problems = append(problems, "TTS_BACKEND must be openai or command")
+	}
+	switch e.ImageBackend {
+	case "":
+	case "openai":
+		if e.ImageAPIKey == "" && e.ImageURL == "" {
+			problems = append(problems, "IMAGE_API_KEY is needed by the openai image generation backend")
+		}
+	default:
+		problems = append(problems, "IMAGE_BACKEND must be openai")
+	}
+	if !ValidImageSize(e.ImageSize) {
+		problems = append(problems, fmt.Sprintf("IMAGE_SIZE must be one of %s", strings.Join(IMAGE_SIZES, ", ")))
+	}
+	if e.ImageMaxCount > 10 {
+		problems = append(problems, "IMAGE_MAX_COUNT can't be more than 10")
+	}
+	if e.OTLPEndpoint != "" {
+		if u, err := url.Parse(e.OTLPEndpoint); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, "OTLP_ENDPOINT must be an http or https URL, like http://localhost:4318")
+		}
+	}
+	for key, value := range map[string]string{
+		"CONFIG_ENCRYPTION_KEY":     e.ConfigEncryptionKey,
+		"NEW_CONFIG_ENCRYPTION_KEY": e.NewConfigEncryptionKey,
+	} {
+		if _, err := parseKey(value); value != "" && err != nil {
+			problems = append(problems, fmt.Sprintf("%s is invalid: %v", key, err))
+		}
+	}
+	for key, path := range map[string]string{
+		"OPENAI_SESSION_FILE":        e.OpenAISessionFile,
+		"OPENAI_COOKIES_FILE":        e.OpenAICookiesFile,
+		"CONFIG_ENCRYPTION_KEY_FILE": e.ConfigEncryptionKeyFile,
+	} {
+		if path != "" && !fileExists(path) {
+			problems = append(problems, fmt.Sprintf("%s points to %s, which does not exist", key, path))
+		}
+	}
+
+	names := map[string]bool{}
+	for i, account := range e.Accounts {
+		switch {
+		case account.Name == "":
+			problems = append(problems, fmt.Sprintf("account #%d has no name", i+1))
+		case names[account.Name]:
+			problems = append(problems, fmt.Sprintf("account %s is defined more than once", account.Name))
+		case account.SessionToken != "" && account.APIKey != "":
+			problems = append(problems, fmt.Sprintf("account %s has both a session token and an API key", account.Name))
+		case account.BaseURL != "" && account.APIKey == "":
+			problems = append(problems, fmt.Sprintf("account %s has a base URL but no API key", account.Name))
+		}
+		names[account.Name] = true
+	}
+
+	return problems
+}
+
+// Redacted returns the settings as a YAML config file, with secrets redacted
+func (e *Settings) Redacted() ([]byte, error) {
+	out := map[string]interface{}{}
+	value := reflect.ValueOf(*e)
+	for _, s := range settingKeys() {
+		field := value.FieldByIndex(s.field.Index)
+		if s.secret && !field.IsZero() {
+			out[strings.ToLower(s.key)] = redacted
+		} else {
+			out[strings.ToLower(s.key)] = field.Interface()
+		}
+	}
+
+	accounts := make([]Account, 0, len(e.Accounts))
+	for _, account := range e.Accounts {
+		if account.SessionToken != "" {
+			account.SessionToken = redacted
+		}
+		if account.APIKey != "" {
+			account.APIKey = redacted
+		}
+		accounts = append(accounts, account)
+	}
+	out["accounts"] = accounts
+
+	return yaml.Marshal(out)
+}
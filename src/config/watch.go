@@ -0,0 +1,176 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+)
+
+// reloadDelay groups the events of a single save (editors often write a file in several steps)
+const reloadDelay = 200 * time.Millisecond
+
+// Watcher keeps settings up to date with the files they were loaded from. Only keys tagged with reload are
+// applied, changes to any other key are logged and need a restart. Invalid changes are rejected as a whole.
+type Watcher struct {
+	opts     LoadOptions
+	onChange func(*Settings)
+	current  atomic.Pointer[Settings]
+	mu       sync.Mutex // serializes reloads
+	watcher  *fsnotify.Watcher
+}
+
+// NewWatcher returns a watcher for settings loaded with opts. onChange is called with the new settings after every
+// reload that changed something; it mustn't modify them. Call Start to watch the files.
+func NewWatcher(opts LoadOptions, settings *Settings, onChange func(*Settings)) *Watcher {
+	w := &Watcher{opts: opts, onChange: onChange}
+	w.current.Store(settings)
+	return w
+}
+
+// Settings returns the current settings, which mustn't be modified
+func (w *Watcher) Settings() *Settings {
+	return w.current.Load()
+}
+
+// Start watches the files in the load options, reloading the settings when they change
+func (w *Watcher) Start() error {
+	var files []string
+	for _, file := range []string{w.opts.EnvFile, w.opts.File} {
+		if file != "" {
+			files = append(files, filepath.Clean(file))
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't watch config files: %v", err))
+	}
+
+	// watch the directories, so files that are replaced (or created later) are still noticed
+	realPaths := map[string]string{}
+	for _, file := range files {
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			watcher.Close()
+			return errors.New(fmt.Sprintf("Couldn't watch %s: %v", file, err))
+		}
+		realPaths[file], _ = filepath.EvalSymlinks(file)
+	}
+	w.watcher = watcher
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				changed := false
+				for _, file := range files {
+					// a symlinked file (like a Kubernetes ConfigMap) changes when its target does
+					realPath, _ := filepath.EvalSymlinks(file)
+					if filepath.Clean(event.Name) == file || realPath != realPaths[file] {
+						realPaths[file] = realPath
+						changed = true
+					}
+				}
+				if !changed || event.Op == fsnotify.Chmod {
+					continue
+				}
+
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() {
+					if err := w.Reload(); err != nil {
+						logger.Errorf("Ignoring config change: %v", err)
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf("Error watching config files: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops watching the files
+func (w *Watcher) Close() error {
+	if w.watcher == nil {
+		return nil
+	}
+	return w.watcher.Close()
+}
+
+// Reload loads the settings again and applies the keys that can be reloaded
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current := w.Settings()
+	loaded, err := Load(w.opts)
+	if err != nil {
+		return err
+	}
+	loaded.applyDefaults()
+	if loaded.TelegramToken == "" {
+		// it came from the persistent config
+		loaded.TelegramToken = current.TelegramToken
+	}
+
+	next := *current
+	var changes []string
+	for _, s := range settingKeys() {
+		old := reflect.ValueOf(current).Elem().FieldByIndex(s.field.Index)
+		value := reflect.ValueOf(loaded).Elem().FieldByIndex(s.field.Index)
+		if equal(old, value) {
+			continue
+		}
+
+		if !s.reload {
+			logger.Warnf("%s changed, restart the bot to apply it", s.key)
+			continue
+		}
+		reflect.ValueOf(&next).Elem().FieldByIndex(s.field.Index).Set(value)
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", s.key, old.Interface(), value.Interface()))
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	if problems := next.problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	w.current.Store(&next)
+	for _, change := range changes {
+		logger.Infof("Config reloaded, %s", change)
+	}
+	if w.onChange != nil {
+		w.onChange(&next)
+	}
+	return nil
+}
+
+// equal compares two setting values, considering nil and empty slices the same
+func equal(a reflect.Value, b reflect.Value) bool {
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
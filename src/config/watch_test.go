@@ -0,0 +1,35 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(path, []byte("TELEGRAM_TOKEN=abc\nTELEGRAM_ID=1\nRETRY_ATTEMPTS=3"), 0o600))
+
+	opts := LoadOptions{EnvFile: path}
+	settings, err := Load(opts)
+	require.NoError(t, err)
+	require.NoError(t, settings.ValidateWithDefaults())
+
+	var changed *Settings
+	w := NewWatcher(opts, settings, func(s *Settings) { changed = s })
+
+	require.NoError(t, os.WriteFile(path, []byte("TELEGRAM_TOKEN=abc\nTELEGRAM_ID=1,2\nRETRY_ATTEMPTS=4"), 0o600))
+	require.NoError(t, w.Reload())
+	require.Same(t, changed, w.Settings())
+	require.Equal(t, []int64{1, 2}, w.Settings().TelegramID)
+	// not reloadable, applied on restart
+	require.Equal(t, 3, w.Settings().RetryAttempts)
+	require.Equal(t, []int64{1}, settings.TelegramID)
+
+	require.NoError(t, os.WriteFile(path, []byte("TELEGRAM_TOKEN=abc\nTELEGRAM_ID=3\nRATE_LIMIT_MESSAGES=-1"), 0o600))
+	var validationErr *ValidationError
+	require.ErrorAs(t, w.Reload(), &validationErr)
+	require.Equal(t, []int64{1, 2}, w.Settings().TelegramID)
+}
@@ -0,0 +1,93 @@
+package documents
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ErrUnsupported is returned for files whose text can't be extracted
+var ErrUnsupported = errors.New("Only text, code, Markdown, JSON, CSV and PDF files can be read")
+
+// textExtensions are read as plain text, even if they don't look like it to http.DetectContentType
+var textExtensions = map[string]bool{
+	".txt": true, ".log": true, ".md": true, ".markdown": true, ".json": true, ".jsonl": true, ".csv": true, ".tsv": true,
+	".xml": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".env": true, ".conf": true, ".cfg": true,
+	".go": true, ".py": true, ".js": true, ".ts": true, ".jsx": true, ".tsx": true, ".java": true, ".kt": true, ".c": true,
+	".h": true, ".cpp": true, ".hpp": true, ".cs": true, ".rs": true, ".rb": true, ".php": true, ".swift": true, ".sh": true,
+	".sql": true, ".html": true, ".css": true, ".scss": true, ".lua": true, ".r": true, ".scala": true, ".dart": true,
+}
+
+// Extract returns the text of a file. name is the file name, its extension helps telling the format.
+func Extract(data []byte, name string) (string, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".pdf" || bytes.HasPrefix(data, []byte("%PDF-")) {
+		return extractPDF(data)
+	}
+
+	if !textExtensions[ext] && !strings.HasPrefix(http.DetectContentType(data), "text/") {
+		return "", ErrUnsupported
+	}
+	if !utf8.Valid(data) {
+		return "", errors.New("The file isn't UTF-8 text")
+	}
+
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n")), nil
+}
+
+// extractPDF returns the text layer of a PDF, which is empty for scanned documents
+func extractPDF(data []byte) (text string, err error) {
+	// the PDF reader panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("Couldn't read PDF: %v", r))
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't read PDF: %v", err))
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't read PDF: %v", err))
+	}
+	out, err := io.ReadAll(plain)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't read PDF: %v", err))
+	}
+
+	if text = strings.TrimSpace(string(out)); text == "" {
+		return "", errors.New("The PDF has no text, scanned documents can't be read")
+	}
+	return text, nil
+}
+
+// Chunk splits text into pieces of at most size characters, breaking at line ends when possible
+func Chunk(text string, size int) []string {
+	var chunks []string
+	runes := []rune(text)
+	for len(runes) > size {
+		end := size
+		for i := size; i > size/2; i-- {
+			if runes[i-1] == '\n' {
+				end = i
+				break
+			}
+		}
+
+		chunks = append(chunks, string(runes[:end]))
+		runes = runes[end:]
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
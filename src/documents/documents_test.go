@@ -0,0 +1,29 @@
+package documents
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtract(t *testing.T) {
+	text, err := Extract([]byte("{\"level\": \"error\"}\r\n"), "app.json")
+	require.NoError(t, err)
+	require.Equal(t, `{"level": "error"}`, text)
+
+	text, err = Extract([]byte("panic: oops\n"), "crash")
+	require.NoError(t, err)
+	require.Equal(t, "panic: oops", text)
+
+	_, err = Extract([]byte{0x89, 'P', 'N', 'G', 0, 0}, "image.png")
+	require.Equal(t, ErrUnsupported, err)
+
+	_, err = Extract([]byte("%PDF-1.4 broken"), "report.pdf")
+	require.Error(t, err)
+}
+
+func TestChunk(t *testing.T) {
+	require.Equal(t, []string{"one\n", "two\n", "three"}, Chunk("one\ntwo\nthree", 6))
+	require.Equal(t, []string{"abcd", "ef"}, Chunk("abcdef", 4))
+	require.Empty(t, Chunk("", 4))
+}
@@ -1,57 +1,200 @@
 package expirymap
 
 import (
+	"container/list"
 	"sync"
 	"time"
 )
 
-type ExpiryMap struct {
-	// The internal map that stores the key-value pairs
-	m map[string]string
+// DefaultCleanupInterval is how often expired entries are removed in the background
+const DefaultCleanupInterval = time.Minute
 
-	// A mutex that protects access to the map
-	mutex sync.RWMutex
+type entry[K comparable, V any] struct {
+	key    K
+	value  V
+	expiry time.Time
+}
+
+// ExpiryMap is a concurrency-safe map whose entries expire after a given duration.
+// It can optionally be bounded, in which case the least recently used entries are evicted first.
+type ExpiryMap[K comparable, V any] struct {
+	// A mutex that protects access to the following
+	mutex sync.Mutex
+
+	// The entries, indexing into order
+	items map[K]*list.Element
+
+	// Entries from most to least recently used
+	order *list.List
+
+	// The maximum amount of entries, 0 means unbounded
+	maxSize int
+
+	// Called (without holding the mutex) when an entry expires or is evicted to make room
+	onEvict func(key K, value V)
+
+	cleanupInterval time.Duration
+	stop            chan struct{}
+	closeOnce       sync.Once
+}
+
+type Option[K comparable, V any] func(*ExpiryMap[K, V])
+
+// WithMaxSize bounds the map, evicting the least recently used entries when it's full
+func WithMaxSize[K comparable, V any](size int) Option[K, V] {
+	return func(em *ExpiryMap[K, V]) {
+		em.maxSize = size
+	}
+}
+
+// WithOnEvict sets a callback for entries that expire or are evicted. It isn't called for deleted or replaced entries.
+func WithOnEvict[K comparable, V any](f func(key K, value V)) Option[K, V] {
+	return func(em *ExpiryMap[K, V]) {
+		em.onEvict = f
+	}
+}
 
-	// A map that stores the expiration time for each key-value pair
-	expiryMap map[string]time.Time
+// WithCleanupInterval changes how often expired entries are removed in the background, 0 disables it
+func WithCleanupInterval[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(em *ExpiryMap[K, V]) {
+		em.cleanupInterval = d
+	}
 }
 
-func New() ExpiryMap {
-	return ExpiryMap{
-		m:         make(map[string]string),
-		expiryMap: make(map[string]time.Time),
+// New creates an ExpiryMap, starting a background goroutine to remove expired entries.
+// Call Close to stop it once the map isn't needed anymore.
+func New[K comparable, V any](opts ...Option[K, V]) *ExpiryMap[K, V] {
+	em := &ExpiryMap[K, V]{
+		items:           make(map[K]*list.Element),
+		order:           list.New(),
+		cleanupInterval: DefaultCleanupInterval,
+		stop:            make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(em)
+	}
+
+	if em.cleanupInterval > 0 {
+		go em.janitor()
+	}
+
+	return em
 }
 
-func (em *ExpiryMap) Set(key string, value string, expiry time.Duration) {
+func (em *ExpiryMap[K, V]) Set(key K, value V, expiry time.Duration) {
 	em.mutex.Lock()
-	defer em.mutex.Unlock()
 
-	em.m[key] = value
-	em.expiryMap[key] = time.Now().Add(expiry)
+	e := &entry[K, V]{key: key, value: value, expiry: time.Now().Add(expiry)}
+	if el, ok := em.items[key]; ok {
+		el.Value = e
+		em.order.MoveToFront(el)
+	} else {
+		em.items[key] = em.order.PushFront(e)
+	}
+
+	var evicted []*entry[K, V]
+	for em.maxSize > 0 && em.order.Len() > em.maxSize {
+		evicted = append(evicted, em.remove(em.order.Back()))
+	}
+	em.mutex.Unlock()
+
+	em.evict(evicted)
 }
 
-func (em *ExpiryMap) Get(key string) (string, bool) {
-	em.mutex.RLock()
-	defer em.mutex.RUnlock()
+func (em *ExpiryMap[K, V]) Get(key K) (V, bool) {
+	em.mutex.Lock()
 
-	if value, ok := em.m[key]; ok {
-		expiry := em.expiryMap[key]
-		if time.Now().Before(expiry) {
-			return value, true
-		}
+	el, ok := em.items[key]
+	if !ok {
+		em.mutex.Unlock()
+		var zero V
+		return zero, false
+	}
 
-		delete(em.m, key)
-		delete(em.expiryMap, key)
+	e := el.Value.(*entry[K, V])
+	if time.Now().Before(e.expiry) {
+		em.order.MoveToFront(el)
+		em.mutex.Unlock()
+		return e.value, true
 	}
 
-	return "", false
+	em.remove(el)
+	em.mutex.Unlock()
+
+	em.evict([]*entry[K, V]{e})
+	var zero V
+	return zero, false
+}
+
+func (em *ExpiryMap[K, V]) Delete(key K) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	if el, ok := em.items[key]; ok {
+		em.remove(el)
+	}
 }
 
-func (em *ExpiryMap) Delete(key string) {
+// Len returns the amount of entries, including expired ones that haven't been removed yet
+func (em *ExpiryMap[K, V]) Len() int {
 	em.mutex.Lock()
 	defer em.mutex.Unlock()
 
-	delete(em.m, key)
-	delete(em.expiryMap, key)
+	return em.order.Len()
+}
+
+// Close stops the background cleanup. The map can still be used afterwards.
+func (em *ExpiryMap[K, V]) Close() {
+	em.closeOnce.Do(func() {
+		close(em.stop)
+	})
+}
+
+// remove deletes an element, the mutex must be held
+func (em *ExpiryMap[K, V]) remove(el *list.Element) *entry[K, V] {
+	e := em.order.Remove(el).(*entry[K, V])
+	delete(em.items, e.key)
+	return e
+}
+
+func (em *ExpiryMap[K, V]) evict(entries []*entry[K, V]) {
+	if em.onEvict == nil {
+		return
+	}
+
+	for _, e := range entries {
+		em.onEvict(e.key, e.value)
+	}
+}
+
+// removeExpired deletes every expired entry
+func (em *ExpiryMap[K, V]) removeExpired() {
+	em.mutex.Lock()
+
+	now := time.Now()
+	var expired []*entry[K, V]
+	for el := em.order.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*entry[K, V]); !now.Before(e.expiry) {
+			expired = append(expired, em.remove(el))
+		}
+		el = next
+	}
+	em.mutex.Unlock()
+
+	em.evict(expired)
+}
+
+func (em *ExpiryMap[K, V]) janitor() {
+	ticker := time.NewTicker(em.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			em.removeExpired()
+		case <-em.stop:
+			return
+		}
+	}
 }
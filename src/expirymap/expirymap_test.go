@@ -0,0 +1,117 @@
+package expirymap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSet(t *testing.T) {
+	em := New[string, int]()
+	t.Cleanup(em.Close)
+
+	_, ok := em.Get("a")
+	require.False(t, ok)
+
+	em.Set("a", 1, time.Minute)
+	value, ok := em.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, value)
+
+	em.Set("a", 2, time.Minute)
+	value, ok = em.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, value)
+	require.Equal(t, 1, em.Len())
+
+	em.Delete("a")
+	_, ok = em.Get("a")
+	require.False(t, ok)
+	require.Equal(t, 0, em.Len())
+}
+
+func TestExpiry(t *testing.T) {
+	var evicted []string
+	em := New(
+		WithCleanupInterval[string, string](0),
+		WithOnEvict(func(key string, value string) { evicted = append(evicted, key) }),
+	)
+
+	em.Set("expired", "value", -time.Second)
+	em.Set("valid", "value", time.Minute)
+
+	_, ok := em.Get("expired")
+	require.False(t, ok)
+	_, ok = em.Get("valid")
+	require.True(t, ok)
+
+	require.Equal(t, []string{"expired"}, evicted)
+	require.Equal(t, 1, em.Len())
+}
+
+func TestJanitor(t *testing.T) {
+	evicted := make(chan string, 1)
+	em := New(
+		WithCleanupInterval[string, int](10*time.Millisecond),
+		WithOnEvict(func(key string, value int) { evicted <- key }),
+	)
+	t.Cleanup(em.Close)
+
+	em.Set("a", 1, time.Millisecond)
+
+	select {
+	case key := <-evicted:
+		require.Equal(t, "a", key)
+	case <-time.After(time.Second):
+		t.Fatal("expired entry wasn't removed in the background")
+	}
+	require.Equal(t, 0, em.Len())
+}
+
+func TestMaxSize(t *testing.T) {
+	var evicted []int
+	em := New(
+		WithMaxSize[int, string](2),
+		WithOnEvict(func(key int, value string) { evicted = append(evicted, key) }),
+	)
+	t.Cleanup(em.Close)
+
+	em.Set(1, "one", time.Minute)
+	em.Set(2, "two", time.Minute)
+	// 1 becomes the most recently used, so 2 gets evicted
+	_, ok := em.Get(1)
+	require.True(t, ok)
+	em.Set(3, "three", time.Minute)
+
+	require.Equal(t, []int{2}, evicted)
+	require.Equal(t, 2, em.Len())
+	_, ok = em.Get(2)
+	require.False(t, ok)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	em := New[int, int](WithMaxSize[int, int](50), WithCleanupInterval[int, int](time.Millisecond))
+	t.Cleanup(em.Close)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				em.Set(j%100, i, time.Duration(j%3)*time.Millisecond)
+				em.Get(j % 100)
+				if j%10 == 0 {
+					em.Delete(j % 100)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, em.Len(), 50)
+	em.Close()
+	em.Close()
+}
@@ -0,0 +1,119 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checker answers liveness and readiness probes
+type Checker struct {
+	// stuckAfter is how long an update can take to be handled before the bot is considered wedged
+	stuckAfter time.Duration
+	// updateStarted is when the update being handled started (in unix nanoseconds), or 0 if there's none
+	updateStarted atomic.Int64
+
+	// ready checks the dependencies of the bot, giving up after readyTimeout. Its result is cached for readyCacheFor
+	ready         func(ctx context.Context) error
+	readyTimeout  time.Duration
+	readyCacheFor time.Duration
+	mu            sync.Mutex // protects following
+	checkedAt     time.Time
+	readyErr      error
+}
+
+// New returns a checker that considers the bot stuck when an update takes longer than stuckAfter, and
+// ready when ready returns nil within readyTimeout. ready is called at most once every readyCacheFor.
+func New(stuckAfter time.Duration, ready func(ctx context.Context) error, readyTimeout time.Duration, readyCacheFor time.Duration) *Checker {
+	return &Checker{
+		stuckAfter:    stuckAfter,
+		ready:         ready,
+		readyTimeout:  readyTimeout,
+		readyCacheFor: readyCacheFor,
+	}
+}
+
+// StartUpdate is called when the update loop starts handling an update
+func (c *Checker) StartUpdate() {
+	c.updateStarted.Store(time.Now().UnixNano())
+}
+
+// FinishUpdate is called once the update has been handled
+func (c *Checker) FinishUpdate() {
+	c.updateStarted.Store(0)
+}
+
+// Alive returns an error if the update loop has been handling the same update for too long.
+// Waiting for updates is fine, no matter how long it takes.
+func (c *Checker) Alive() error {
+	started := c.updateStarted.Load()
+	if started == 0 {
+		return nil
+	}
+
+	if busy := time.Since(time.Unix(0, started)); busy > c.stuckAfter {
+		return errors.New(fmt.Sprintf("Update loop stuck on the same update for %v", busy.Round(time.Second)))
+	}
+	return nil
+}
+
+// Ready returns the error of the last readiness check, checking again if it's older than readyCacheFor.
+// The lock isn't held while checking, so a slow check doesn't hold up other probes.
+func (c *Checker) Ready() error {
+	c.mu.Lock()
+	fresh := !c.checkedAt.IsZero() && time.Since(c.checkedAt) <= c.readyCacheFor
+	readyErr := c.readyErr
+	c.mu.Unlock()
+	if fresh {
+		return readyErr
+	}
+
+	readyErr = c.check()
+
+	c.mu.Lock()
+	c.readyErr = readyErr
+	c.checkedAt = time.Now()
+	c.mu.Unlock()
+	return readyErr
+}
+
+// check runs the readiness check, giving up after readyTimeout even if it doesn't stop when its context is done
+func (c *Checker) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.readyTimeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() { result <- c.ready(ctx) }()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return errors.New(fmt.Sprintf("Readiness check timed out after %v", c.readyTimeout))
+	}
+}
+
+// Register adds the /healthz and /readyz endpoints to mux
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", probeHandler(c.Alive))
+	mux.HandleFunc("/readyz", probeHandler(c.Ready))
+}
+
+// probeHandler responds with 200 when check succeeds, and 503 with the error otherwise
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func probe(t *testing.T, mux *http.ServeMux, path string) int {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec.Code
+}
+
+func TestHealthz(t *testing.T) {
+	c := New(50*time.Millisecond, func(ctx context.Context) error { return nil }, time.Second, time.Minute)
+	mux := http.NewServeMux()
+	c.Register(mux)
+
+	require.Equal(t, http.StatusOK, probe(t, mux, "/healthz"))
+
+	c.StartUpdate()
+	require.Equal(t, http.StatusOK, probe(t, mux, "/healthz"))
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, http.StatusServiceUnavailable, probe(t, mux, "/healthz"))
+
+	c.FinishUpdate()
+	require.Equal(t, http.StatusOK, probe(t, mux, "/healthz"))
+}
+
+func TestReadyzIsCached(t *testing.T) {
+	calls := 0
+	var readyErr error
+	c := New(time.Minute, func(ctx context.Context) error {
+		calls++
+		return readyErr
+	}, time.Second, 50*time.Millisecond)
+	mux := http.NewServeMux()
+	c.Register(mux)
+
+	require.Equal(t, http.StatusOK, probe(t, mux, "/readyz"))
+
+	readyErr = errors.New("Telegram is down")
+	require.Equal(t, http.StatusOK, probe(t, mux, "/readyz"))
+	require.Equal(t, 1, calls)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, http.StatusServiceUnavailable, probe(t, mux, "/readyz"))
+	require.Equal(t, 2, calls)
+}
+
+func TestReadyzTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	c := New(time.Minute, func(ctx context.Context) error {
+		// a check that doesn't stop when its context is done
+		<-release
+		return nil
+	}, 50*time.Millisecond, time.Minute)
+
+	start := time.Now()
+	require.ErrorContains(t, c.Ready(), "timed out")
+	require.Less(t, time.Since(start), time.Second)
+
+	// the failure is cached like any other result
+	require.ErrorContains(t, c.Ready(), "timed out")
+}
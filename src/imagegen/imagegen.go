@@ -0,0 +1,147 @@
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BACKEND_OPENAI is the OpenAI API, or any service compatible with its images endpoint
+const BACKEND_OPENAI = "openai"
+
+const DEFAULT_OPENAI_URL = "https://api.openai.com/v1"
+const DEFAULT_MODEL = "dall-e-2"
+const DEFAULT_SIZE = "1024x1024"
+
+// maxImageSize is the most bytes read from an image returned as a URL
+const maxImageSize = 20 << 20
+
+// requestTimeout bounds generating the images, which takes a while, and downloading them
+const requestTimeout = 3 * time.Minute
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// Generator draws images from a text description
+type Generator interface {
+	// Generate returns count images of prompt, as PNG or JPEG. size is like 1024x1024.
+	Generate(ctx context.Context, prompt string, size string, count int) ([][]byte, error)
+}
+
+// NewGenerator returns the generator for backend, or nil if backend is empty.
+// baseURL and model fall back to the defaults of the backend when empty.
+func NewGenerator(backend string, baseURL string, apiKey string, model string) (Generator, error) {
+	switch backend {
+	case "":
+		return nil, nil
+	case BACKEND_OPENAI:
+		if baseURL == "" {
+			baseURL = DEFAULT_OPENAI_URL
+		}
+		if model == "" {
+			model = DEFAULT_MODEL
+		}
+		return &openAIGenerator{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, model: model}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown image backend %s", backend))
+	}
+}
+
+type openAIGenerator struct {
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+type generationResponse struct {
+	Data []struct {
+		B64JSON string `json:"b64_json"`
+		URL     string `json:"url"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (g *openAIGenerator) Generate(ctx context.Context, prompt string, size string, count int) ([][]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":           g.model,
+		"prompt":          prompt,
+		"n":               count,
+		"size":            size,
+		"response_format": "b64_json",
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't encode request: %v", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/images/generations", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't create request: %v", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.apiKey))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't reach the image backend: %v", err))
+	}
+	defer resp.Body.Close()
+
+	var res generationResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&res); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Couldn't read the response of the image backend: %v", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		if res.Error != nil && res.Error.Message != "" {
+			return nil, errors.New(fmt.Sprintf("%s (%s)", res.Error.Message, resp.Status))
+		}
+		return nil, errors.New(fmt.Sprintf("The image backend responded with %s", resp.Status))
+	}
+
+	var images [][]byte
+	for _, data := range res.Data {
+		var image []byte
+		if data.B64JSON != "" {
+			image, err = base64.StdEncoding.DecodeString(data.B64JSON)
+		} else {
+			// some compatible services ignore response_format and only return links
+			image, err = download(ctx, data.URL)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Couldn't read the generated image: %v", err))
+		}
+		images = append(images, image)
+	}
+	if len(images) == 0 {
+		return nil, errors.New("The image backend didn't return any image")
+	}
+
+	return images, nil
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxImageSize))
+}
@@ -0,0 +1,61 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAIGenerator(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/images/generations", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+
+		var req map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, map[string]interface{}{"model": "dall-e-2", "prompt": "a cat", "n": 2.0, "size": "512x512", "response_format": "b64_json"}, req)
+
+		fmt.Fprintf(w, `{"data": [{"b64_json": %q}, {"url": "http://%s/image.png"}]}`, base64.StdEncoding.EncodeToString([]byte("first")), r.Host)
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("second"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	generator, err := NewGenerator(BACKEND_OPENAI, srv.URL+"/v1/", "key", "")
+	require.NoError(t, err)
+
+	images, err := generator.Generate(context.Background(), "a cat", "512x512", 2)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("first"), []byte("second")}, images)
+}
+
+func TestOpenAIGeneratorError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"message": "Your request was rejected"}}`))
+	}))
+	defer srv.Close()
+
+	generator, err := NewGenerator(BACKEND_OPENAI, srv.URL, "", "")
+	require.NoError(t, err)
+
+	_, err = generator.Generate(context.Background(), "a cat", DEFAULT_SIZE, 1)
+	require.EqualError(t, err, "Your request was rejected (400 Bad Request)")
+}
+
+func TestNewGenerator(t *testing.T) {
+	generator, err := NewGenerator("", "", "", "")
+	require.NoError(t, err)
+	require.Nil(t, generator)
+
+	_, err = NewGenerator("nope", "", "", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,224 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name (debug, info, warn or error), an empty name is info
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) || (level == LevelWarn && strings.EqualFold(name, "warning")) {
+			return level, nil
+		}
+	}
+	return LevelInfo, errors.New(fmt.Sprintf("Unknown log level %s", name))
+}
+
+type Format int32
+
+const (
+	// FormatText writes lines like the standard log package, followed by key=value fields
+	FormatText Format = iota
+	// FormatJSON writes an object per line, with the time, level, msg and fields as keys
+	FormatJSON
+)
+
+// ParseFormat parses a format name (text or json), an empty name is text
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return FormatText, errors.New(fmt.Sprintf("Unknown log format %s", name))
+	}
+}
+
+// Content is text written by users or the model. It's redacted unless SetLogContent(true) was called.
+type Content string
+
+var (
+	minLevel   atomic.Int32
+	format     atomic.Int32
+	logContent atomic.Bool
+	mu         sync.Mutex // protects output
+	output     io.Writer  = os.Stderr
+)
+
+func init() {
+	minLevel.Store(int32(LevelInfo))
+}
+
+// SetLevel changes the minimum level of the messages that are logged
+func SetLevel(level Level) {
+	minLevel.Store(int32(level))
+}
+
+func SetFormat(f Format) {
+	format.Store(int32(f))
+}
+
+// SetLogContent chooses between logging Content fields as is, or only their length
+func SetLogContent(enabled bool) {
+	logContent.Store(enabled)
+}
+
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	output = w
+}
+
+func Enabled(level Level) bool {
+	return level >= Level(minLevel.Load())
+}
+
+// Logger adds fields to every message it logs
+type Logger struct {
+	// fields are key/value pairs
+	fields []interface{}
+}
+
+var root = &Logger{}
+
+// With returns a logger with extra fields, given as alternating keys and values
+func With(keysAndValues ...interface{}) *Logger {
+	return root.With(keysAndValues...)
+}
+
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	return &Logger{fields: append(append(fields, l.fields...), keysAndValues...)}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
+}
+
+func (l *Logger) log(level Level, msgFormat string, args ...interface{}) {
+	if !Enabled(level) {
+		return
+	}
+
+	now := time.Now()
+	msg := fmt.Sprintf(msgFormat, args...)
+
+	var line []byte
+	if Format(format.Load()) == FormatJSON {
+		entry := map[string]interface{}{
+			"time":  now.Format(time.RFC3339Nano),
+			"level": strings.ToLower(level.String()),
+			"msg":   msg,
+		}
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			entry[fmt.Sprint(l.fields[i])] = fieldValue(l.fields[i+1])
+		}
+
+		var err error
+		if line, err = json.Marshal(entry); err != nil {
+			line = []byte(strconv.Quote(fmt.Sprintf("%s %s (couldn't encode fields: %v)", level, msg, err)))
+		}
+	} else {
+		var text strings.Builder
+		text.WriteString(now.Format("2006/01/02 15:04:05 "))
+		text.WriteString(level.String() + " " + msg)
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			value := fmt.Sprint(fieldValue(l.fields[i+1]))
+			if value == "" || strings.ContainsAny(value, " =\"\n") {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&text, " %v=%s", l.fields[i], value)
+		}
+		line = []byte(text.String())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	output.Write(append(line, '\n'))
+}
+
+// fieldValue prepares a field to be logged, redacting content when needed
+func fieldValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case Content:
+		if !logContent.Load() {
+			return fmt.Sprintf("[%d characters]", utf8.RuneCountInString(string(value)))
+		}
+		return string(value)
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return value
+	}
+}
+
+func Debugf(format string, args ...interface{}) {
+	root.log(LevelDebug, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	root.log(LevelInfo, format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	root.log(LevelWarn, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	root.log(LevelError, format, args...)
+}
+
+// Fatalf logs an error and exits. It's only meant for startup, never for handling a request.
+func Fatalf(format string, args ...interface{}) {
+	root.log(LevelError, format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func capture(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	t.Cleanup(func() {
+		SetOutput(os.Stderr)
+		SetFormat(FormatText)
+		SetLogContent(false)
+		SetLevel(LevelInfo)
+	})
+	return &buf
+}
+
+func TestTextFields(t *testing.T) {
+	buf := capture(t)
+
+	With("chat_id", 42).With("account", "work account").Infof("Answered in %d ms", 10)
+	Debugf("hidden")
+
+	require.Regexp(t, `^\S+ \S+ INFO Answered in 10 ms chat_id=42 account="work account"\n$`, buf.String())
+}
+
+func TestJSONFields(t *testing.T) {
+	buf := capture(t)
+	SetFormat(FormatJSON)
+
+	With("user_id", int64(7)).Warnf("Rate limited")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "warn", entry["level"])
+	require.Equal(t, "Rate limited", entry["msg"])
+	require.Equal(t, float64(7), entry["user_id"])
+}
+
+func TestContentRedaction(t *testing.T) {
+	buf := capture(t)
+
+	With("prompt", Content("héllo")).Infof("Received prompt")
+	require.Contains(t, buf.String(), `prompt="[5 characters]"`)
+	require.NotContains(t, buf.String(), "héllo")
+
+	buf.Reset()
+	SetLogContent(true)
+	With("prompt", Content("héllo")).Infof("Received prompt")
+	require.Contains(t, buf.String(), "prompt=héllo")
+}
@@ -1,6 +1,9 @@
 package markdown
 
-import "strings"
+import (
+	"regexp"
+	"strings"
+)
 
 func EnsureFormatting(text string) string {
 	numDelimiters := strings.Count(text, "```")
@@ -15,3 +18,29 @@ func EnsureFormatting(text string) string {
 
 	return text
 }
+
+var (
+	codeBlockRegex  = regexp.MustCompile("(?s)```.*?(```|$)")
+	linkRegex       = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	headingRegex    = regexp.MustCompile(`(?m)^[ \t]*#{1,6}[ \t]+`)
+	quoteRegex      = regexp.MustCompile(`(?m)^[ \t]*>[ \t]?`)
+	bulletRegex     = regexp.MustCompile(`(?m)^([ \t]*)[-*+][ \t]+`)
+	emphasisRegex   = regexp.MustCompile(`\*+|~~|__`)
+	underscoreRegex = regexp.MustCompile(`(^|[\s(])_+|_+([\s).,!?:;]|$)`)
+	blankLinesRegex = regexp.MustCompile(`\n{3,}`)
+)
+
+// StripFormatting turns markdown into plain text, dropping code blocks entirely, so it can be read out loud
+func StripFormatting(text string) string {
+	text = codeBlockRegex.ReplaceAllString(text, "")
+	text = linkRegex.ReplaceAllString(text, "$1")
+	text = headingRegex.ReplaceAllString(text, "")
+	text = quoteRegex.ReplaceAllString(text, "")
+	text = bulletRegex.ReplaceAllString(text, "$1")
+	text = emphasisRegex.ReplaceAllString(text, "")
+	text = underscoreRegex.ReplaceAllString(text, "$1$2")
+	text = strings.ReplaceAll(text, "`", "")
+	text = blankLinesRegex.ReplaceAllString(text, "\n\n")
+
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,17 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripFormatting(t *testing.T) {
+	text := "# Sorting\n\nUse **sort.Slice** from the `sort` package, see [the docs](https://pkg.go.dev/sort):\n\n```go\nsort.Slice(s, less)\n```\n\n- it's _fast_\n- it sorts in_place\n\n> ~~slow~~ done"
+
+	require.Equal(t, "Sorting\n\nUse sort.Slice from the sort package, see the docs:\n\nit's fast\nit sorts in_place\n\nslow done", StripFormatting(text))
+}
+
+func TestStripUnclosedCodeBlock(t *testing.T) {
+	require.Equal(t, "Here's the code:", StripFormatting("Here's the code:\n```\nfmt.Println()"))
+}
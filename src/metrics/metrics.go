@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "chatgpt_telegram"
+
+// answerBuckets go from 250ms to about 2 minutes, since answers can take a while to be generated
+var answerBuckets = prometheus.ExponentialBuckets(0.25, 2, 10)
+
+var (
+	updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "updates_total",
+		Help:      "Telegram updates received, by type.",
+	}, []string{"type"})
+
+	prompts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "prompts_total",
+		Help:      "Prompts sent to ChatGPT, by user and chat (\"other\" when anyone can use the bot).",
+	}, []string{"user_id", "chat_id"})
+
+	timeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "time_to_first_token_seconds",
+		Help:      "Time from sending a prompt until the answer starts arriving, by account.",
+		Buckets:   answerBuckets,
+	}, []string{"account"})
+
+	generationTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "generation_seconds",
+		Help:      "Time from sending a prompt until the answer is complete, by account.",
+		Buckets:   answerBuckets,
+	}, []string{"account"})
+
+	editFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "telegram_edit_failures_total",
+		Help:      "Telegram message edits that failed.",
+	})
+
+	authRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "auth_refreshes_total",
+		Help:      "Access token refreshes of website accounts, by account and result (success or error).",
+	}, []string{"account", "result"})
+
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "upstream_errors_total",
+		Help:      "Failed requests to ChatGPT, by account, kind of error and HTTP status code (0 if there was no response).",
+	}, []string{"account", "kind", "code"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func ObserveUpdate(updateType string) {
+	updates.WithLabelValues(updateType).Inc()
+}
+
+func ObservePrompt(userID int64, chatID int64) {
+	prompts.WithLabelValues(strconv.FormatInt(userID, 10), strconv.FormatInt(chatID, 10)).Inc()
+}
+
+// ObserveOtherPrompt counts a prompt from a user who isn't in the allowlist, without a label of their own
+func ObserveOtherPrompt() {
+	prompts.WithLabelValues("other", "other").Inc()
+}
+
+// ObserveAnswer records how long an answer took to start arriving, and to be complete
+func ObserveAnswer(account string, firstToken time.Duration, total time.Duration) {
+	timeToFirstToken.WithLabelValues(account).Observe(firstToken.Seconds())
+	generationTime.WithLabelValues(account).Observe(total.Seconds())
+}
+
+func ObserveEditFailure() {
+	editFailures.Inc()
+}
+
+func ObserveAuthRefresh(account string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	authRefreshes.WithLabelValues(account, result).Inc()
+}
+
+func ObserveUpstreamError(account string, kind string, statusCode int) {
+	upstreamErrors.WithLabelValues(account, kind, strconv.Itoa(statusCode)).Inc()
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/expirymap"
+)
+
+// Limiter allows a number of events per key (like a user ID) in a sliding window
+type Limiter struct {
+	mu     sync.Mutex // protects following
+	limit  int
+	window time.Duration
+	events *expirymap.ExpiryMap[int64, []time.Time]
+}
+
+// NewLimiter returns a limiter allowing limit events every window, a limit of 0 allows everything
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:  limit,
+		window: window,
+		events: expirymap.New[int64, []time.Time](),
+	}
+}
+
+// SetLimit changes the limit, events already recorded still count towards it
+func (l *Limiter) SetLimit(limit int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit, l.window = limit, window
+}
+
+// Allow records an event for key if it's within the limit. Otherwise, it returns how long until it would be.
+func (l *Limiter) Allow(key int64) (bool, time.Duration) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN records n events for key if they all fit within the limit, and none otherwise.
+// More events than the limit never fit, and the wait returned for them is the whole window.
+func (l *Limiter) AllowN(key int64, n int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	events, _ := l.events.Get(key)
+	var recent []time.Time
+	for _, event := range events {
+		if now.Sub(event) < l.window {
+			recent = append(recent, event)
+		}
+	}
+
+	if n > l.limit {
+		return false, l.window
+	}
+	if len(recent)+n > l.limit {
+		l.events.Set(key, recent, l.window-now.Sub(recent[len(recent)-1]))
+		return false, l.window - now.Sub(recent[len(recent)+n-l.limit-1])
+	}
+
+	for i := 0; i < n; i++ {
+		recent = append(recent, now)
+	}
+	l.events.Set(key, recent, l.window)
+	return true, 0
+}
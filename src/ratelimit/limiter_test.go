@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2, 100*time.Millisecond)
+
+	ok, _ := l.Allow(1)
+	require.True(t, ok)
+	ok, _ = l.Allow(1)
+	require.True(t, ok)
+
+	ok, wait := l.Allow(1)
+	require.False(t, ok)
+	require.Greater(t, wait, time.Duration(0))
+	require.LessOrEqual(t, wait, 100*time.Millisecond)
+
+	// keys are limited separately
+	ok, _ = l.Allow(2)
+	require.True(t, ok)
+
+	time.Sleep(wait + 10*time.Millisecond)
+	ok, _ = l.Allow(1)
+	require.True(t, ok)
+}
+
+func TestAllowN(t *testing.T) {
+	l := NewLimiter(3, time.Minute)
+
+	ok, _ := l.Allow(1)
+	require.True(t, ok)
+
+	// either all of the events fit or none of them count
+	ok, wait := l.AllowN(1, 3)
+	require.False(t, ok)
+	require.Greater(t, wait, time.Duration(0))
+	ok, _ = l.AllowN(1, 2)
+	require.True(t, ok)
+	ok, _ = l.Allow(1)
+	require.False(t, ok)
+
+	ok, wait = l.AllowN(2, 4)
+	require.False(t, ok)
+	require.Equal(t, time.Minute, wait)
+}
+
+func TestLimiterWithoutLimit(t *testing.T) {
+	l := NewLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow(1)
+		require.True(t, ok)
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	l := NewLimiter(1, time.Minute)
+	ok, _ := l.Allow(1)
+	require.True(t, ok)
+	ok, _ = l.Allow(1)
+	require.False(t, ok)
+
+	// events already recorded count towards the new limit
+	l.SetLimit(2, time.Minute)
+	ok, _ = l.Allow(1)
+	require.True(t, ok)
+	ok, _ = l.Allow(1)
+	require.False(t, ok)
+
+	l.SetLimit(0, time.Minute)
+	ok, _ = l.Allow(1)
+	require.True(t, ok)
+}
@@ -0,0 +1,41 @@
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Policy describes how many times a failing operation should be attempted, and how long to wait between attempts.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first one. 1 disables retrying.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+// Backoff returns how long to wait after the given (1-indexed) failed attempt.
+// The delay doubles on every attempt up to MaxDelay, and half of it is randomized
+// so that concurrent callers don't retry in lockstep.
+func (p Policy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := p.MaxDelay
+	if attempt <= 32 {
+		if d := p.BaseDelay << (attempt - 1); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
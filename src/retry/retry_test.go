@@ -0,0 +1,31 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	for _, test := range []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{attempt: 0, delay: time.Second},
+		{attempt: 1, delay: time.Second},
+		{attempt: 2, delay: 2 * time.Second},
+		{attempt: 4, delay: 8 * time.Second},
+		{attempt: 5, delay: 10 * time.Second},
+		{attempt: 100, delay: 10 * time.Second},
+	} {
+		// half of the delay is random
+		for i := 0; i < 20; i++ {
+			backoff := policy.Backoff(test.attempt)
+			require.GreaterOrEqual(t, backoff, test.delay/2, "attempt %d", test.attempt)
+			require.LessOrEqual(t, backoff, test.delay, "attempt %d", test.attempt)
+		}
+	}
+}
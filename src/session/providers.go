@@ -0,0 +1,137 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// COOKIE_NAME is the cookie ChatGPT keeps the session token in
+const COOKIE_NAME = "__Secure-next-auth.session-token"
+
+// FromFile reads a session token from a file, like a Docker or Kubernetes secret mount
+func FromFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't read session file: %v", err))
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errors.New(fmt.Sprintf("Session file %s is empty", path))
+	}
+
+	return token, nil
+}
+
+type exportedCookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Domain string `json:"domain"`
+}
+
+// FromCookies extracts the session token from a browser cookie export, either
+// in the Netscape cookies.txt format or as JSON (a list of cookies, or an object with a "cookies" list).
+func FromCookies(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't read cookies file: %v", err))
+	}
+
+	var cookies []exportedCookie
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		cookies, err = parseJSONCookies(trimmed)
+	} else {
+		cookies, err = parseNetscapeCookies(content)
+	}
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't parse cookies file: %v", err))
+	}
+
+	return sessionFromCookies(cookies)
+}
+
+func parseJSONCookies(content []byte) ([]exportedCookie, error) {
+	var cookies []exportedCookie
+	if content[0] == '[' {
+		err := json.Unmarshal(content, &cookies)
+		return cookies, err
+	}
+
+	var state struct {
+		Cookies []exportedCookie `json:"cookies"`
+	}
+	err := json.Unmarshal(content, &state)
+	return state.Cookies, err
+}
+
+func parseNetscapeCookies(content []byte) ([]exportedCookie, error) {
+	var cookies []exportedCookie
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// HttpOnly cookies are prefixed with "#HttpOnly_", every other line starting with "#" is a comment
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, errors.New(fmt.Sprintf("invalid line: expected 7 tab-separated fields, got %d", len(fields)))
+		}
+
+		cookies = append(cookies, exportedCookie{Domain: fields[0], Name: fields[5], Value: fields[6]})
+	}
+
+	return cookies, scanner.Err()
+}
+
+// sessionFromCookies finds the session token in the cookies for chat.openai.com
+func sessionFromCookies(cookies []exportedCookie) (string, error) {
+	var openAICookies []*http.Cookie
+	for _, cookie := range cookies {
+		if cookie.Domain == "" || strings.HasSuffix(cookie.Domain, "openai.com") {
+			openAICookies = append(openAICookies, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+
+	token := TokenFromCookies(openAICookies)
+	if token == "" {
+		return "", errors.New(fmt.Sprintf("Couldn't find the %s cookie", COOKIE_NAME))
+	}
+	return token, nil
+}
+
+// TokenFromCookies returns the session token in cookies, which is split into numbered chunks
+// ("<name>.0", "<name>.1", ...) when it's too long for a single cookie. It's empty if there's no session cookie.
+func TokenFromCookies(cookies []*http.Cookie) string {
+	var chunks []*http.Cookie
+	for _, cookie := range cookies {
+		if cookie.Name == COOKIE_NAME {
+			return cookie.Value
+		}
+		if strings.HasPrefix(cookie.Name, COOKIE_NAME+".") {
+			chunks = append(chunks, cookie)
+		}
+	}
+
+	// chunks are numbered, so .10 goes after .9
+	sort.Slice(chunks, func(i, j int) bool {
+		return len(chunks[i].Name) < len(chunks[j].Name) || (len(chunks[i].Name) == len(chunks[j].Name) && chunks[i].Name < chunks[j].Name)
+	})
+
+	var token strings.Builder
+	for _, chunk := range chunks {
+		token.WriteString(chunk.Value)
+	}
+	return token.String()
+}
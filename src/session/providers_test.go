@@ -0,0 +1,122 @@
+package session
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenFromCookies(t *testing.T) {
+	for label, test := range map[string]struct {
+		cookies []*http.Cookie
+		want    string
+	}{
+		"single cookie": {
+			cookies: []*http.Cookie{
+				{Name: "other", Value: "x"},
+				{Name: COOKIE_NAME, Value: "token"},
+			},
+			want: "token",
+		},
+		"chunks out of order": {
+			cookies: []*http.Cookie{
+				{Name: COOKIE_NAME + ".10", Value: "c"},
+				{Name: COOKIE_NAME + ".1", Value: "b"},
+				{Name: COOKIE_NAME + ".0", Value: "a"},
+			},
+			want: "abc",
+		},
+		"no cookie": {
+			cookies: []*http.Cookie{{Name: "other", Value: "x"}},
+			want:    "",
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			require.Equal(t, test.want, TokenFromCookies(test.cookies))
+		})
+	}
+}
+
+func TestParseNetscapeCookies(t *testing.T) {
+	for label, test := range map[string]struct {
+		content string
+		want    []exportedCookie
+		wantErr bool
+	}{
+		"comments and blank lines": {
+			content: "# Netscape HTTP Cookie File\n\n.openai.com\tTRUE\t/\tTRUE\t0\tname\tvalue\n",
+			want:    []exportedCookie{{Domain: ".openai.com", Name: "name", Value: "value"}},
+		},
+		"HttpOnly cookie": {
+			content: "#HttpOnly_chat.openai.com\tFALSE\t/\tTRUE\t0\t" + COOKIE_NAME + "\ttoken\n",
+			want:    []exportedCookie{{Domain: "chat.openai.com", Name: COOKIE_NAME, Value: "token"}},
+		},
+		"missing fields": {
+			content: "chat.openai.com\tFALSE\t/\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			cookies, err := parseNetscapeCookies([]byte(test.content))
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, cookies)
+		})
+	}
+}
+
+func TestParseJSONCookies(t *testing.T) {
+	want := []exportedCookie{{Domain: "chat.openai.com", Name: COOKIE_NAME, Value: "token"}}
+
+	for label, content := range map[string]string{
+		"list":   `[{"name": "` + COOKIE_NAME + `", "value": "token", "domain": "chat.openai.com"}]`,
+		"object": `{"cookies": [{"name": "` + COOKIE_NAME + `", "value": "token", "domain": "chat.openai.com"}]}`,
+	} {
+		t.Run(label, func(t *testing.T) {
+			cookies, err := parseJSONCookies([]byte(content))
+			require.NoError(t, err)
+			require.Equal(t, want, cookies)
+		})
+	}
+}
+
+func TestSessionFromCookies(t *testing.T) {
+	for label, test := range map[string]struct {
+		cookies []exportedCookie
+		want    string
+		wantErr bool
+	}{
+		"chunked cookie": {
+			cookies: []exportedCookie{
+				{Domain: "chat.openai.com", Name: COOKIE_NAME + ".1", Value: "b"},
+				{Domain: "chat.openai.com", Name: COOKIE_NAME + ".0", Value: "a"},
+			},
+			want: "ab",
+		},
+		"other domains are ignored": {
+			cookies: []exportedCookie{
+				{Domain: "example.com", Name: COOKIE_NAME, Value: "other"},
+				{Domain: ".openai.com", Name: COOKIE_NAME, Value: "token"},
+			},
+			want: "token",
+		},
+		"no session cookie": {
+			cookies: []exportedCookie{{Domain: "example.com", Name: COOKIE_NAME, Value: "other"}},
+			wantErr: true,
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			token, err := sessionFromCookies(test.cookies)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, token)
+		})
+	}
+}
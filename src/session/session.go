@@ -3,14 +3,23 @@ package session
 import (
 	"errors"
 	"fmt"
-	"log"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
 	"github.com/m1guelpf/chatgpt-telegram/src/ref"
 	"github.com/playwright-community/playwright-go"
 )
 
+// browserDataDir keeps the browser profile between logins
+const browserDataDir = "/tmp/chatgpt"
+
+// ClearBrowserData deletes the browser profile, so the next login starts from scratch
+func ClearBrowserData() error {
+	return os.RemoveAll(browserDataDir)
+}
+
 func GetSession() (string, error) {
 	runOptions := playwright.RunOptions{
 		Browsers: []string{"chromium"},
@@ -44,7 +53,7 @@ func GetSession() (string, error) {
 			Value:    ref.Of(result.SessionToken),
 			Domain:   ref.Of("chat.openai.com"),
 			SameSite: playwright.SameSiteAttributeLax,
-			Name:     ref.Of("__Secure-next-auth.session-token"),
+			Name:     ref.Of(COOKIE_NAME),
 			Expires:  ref.Of(float64(time.Now().AddDate(0, 1, 0).Unix())),
 		}
 
@@ -73,7 +82,7 @@ func GetSession() (string, error) {
 }
 
 func launchBrowser(pw *playwright.Playwright, url string, headless bool) (playwright.BrowserContext, playwright.Page, error) {
-	browser, err := pw.Chromium.LaunchPersistentContext("/tmp/chatgpt", playwright.BrowserTypeLaunchPersistentContextOptions{Headless: playwright.Bool(headless)})
+	browser, err := pw.Chromium.LaunchPersistentContext(browserDataDir, playwright.BrowserTypeLaunchPersistentContextOptions{Headless: playwright.Bool(headless)})
 	if err != nil {
 		return nil, nil, errors.New(fmt.Sprintf("Couldn't launch headless browser: %v", err))
 	}
@@ -108,7 +117,7 @@ func logIn(pw *playwright.Playwright) <-chan Result {
 			r <- Result{Error: errors.New(fmt.Sprintf("Couldn't launch headless browser: %v", err))}
 			return
 		}
-		log.Println("Please log in to OpenAI Chat")
+		logger.Infof("Please log in to OpenAI Chat")
 
 		page.On("framenavigated", func(frame playwright.Frame) {
 			if frame.URL() != "https://chat.openai.com/chat" {
@@ -145,7 +154,7 @@ func getSessionCookie(browser playwright.BrowserContext) (string, error) {
 
 	var sessionToken string
 	for _, cookie := range cookies {
-		if cookie.Name == "__Secure-next-auth.session-token" {
+		if cookie.Name == COOKIE_NAME {
 			sessionToken = cookie.Value
 			break
 		}
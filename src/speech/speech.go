@@ -0,0 +1,147 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// BACKEND_OPENAI is the OpenAI API, or any service compatible with its audio endpoints
+	BACKEND_OPENAI = "openai"
+	// BACKEND_WHISPER is a whisper.cpp server (https://github.com/ggerganov/whisper.cpp/tree/master/examples/server)
+	BACKEND_WHISPER = "whisper"
+)
+
+const DEFAULT_OPENAI_URL = "https://api.openai.com/v1"
+const DEFAULT_TRANSCRIPTION_MODEL = "whisper-1"
+
+// requestTimeout bounds every request to the speech backend, which can take a while with long recordings
+const requestTimeout = 2 * time.Minute
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// Transcriber turns speech into text
+type Transcriber interface {
+	// Transcribe returns the text spoken in audio. name is the file name of the audio, its extension tells the format.
+	Transcribe(ctx context.Context, audio []byte, name string) (string, error)
+}
+
+// NewTranscriber returns the transcriber for backend, or nil if backend is empty. baseURL and model
+// fall back to the defaults of the backend when empty, apiKey is only used by the OpenAI backend.
+func NewTranscriber(backend string, baseURL string, apiKey string, model string) (Transcriber, error) {
+	switch backend {
+	case "":
+		return nil, nil
+	case BACKEND_OPENAI:
+		if baseURL == "" {
+			baseURL = DEFAULT_OPENAI_URL
+		}
+		if model == "" {
+			model = DEFAULT_TRANSCRIPTION_MODEL
+		}
+		return &openAITranscriber{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, model: model}, nil
+	case BACKEND_WHISPER:
+		if baseURL == "" {
+			return nil, errors.New("The whisper backend needs the URL of the server")
+		}
+		return &whisperTranscriber{baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown speech backend %s", backend))
+	}
+}
+
+type openAITranscriber struct {
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+func (t *openAITranscriber) Transcribe(ctx context.Context, audio []byte, name string) (string, error) {
+	fields := map[string]string{"model": t.model, "response_format": "json"}
+	return postAudio(ctx, t.baseURL+"/audio/transcriptions", t.apiKey, fields, audio, name)
+}
+
+type whisperTranscriber struct {
+	baseURL string
+}
+
+func (t *whisperTranscriber) Transcribe(ctx context.Context, audio []byte, name string) (string, error) {
+	fields := map[string]string{"response_format": "json"}
+	return postAudio(ctx, t.baseURL+"/inference", "", fields, audio, name)
+}
+
+// postAudio uploads audio as the file of a multipart form, returning the transcribed text of the response
+func postAudio(ctx context.Context, url string, apiKey string, fields map[string]string, audio []byte, name string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, value := range fields {
+		form.WriteField(key, value)
+	}
+	file, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't create request: %v", err))
+	}
+	file.Write(audio)
+	form.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Couldn't create request: %v", err))
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	}
+
+	var res struct {
+		Text string `json:"text"`
+	}
+	if err := do(req, func(r io.Reader) error { return json.NewDecoder(r).Decode(&res) }); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(res.Text), nil
+}
+
+// do performs the request, passing the response body to read if it succeeds,
+// and turning the error message of the response into an error otherwise.
+func do(req *http.Request, read func(r io.Reader) error) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't reach the speech backend: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+		// OpenAI returns {"error": {"message": "..."}}, while whisper.cpp returns {"error": "..."}
+		var res struct {
+			Error interface{} `json:"error"`
+		}
+		if json.Unmarshal(body, &res) == nil {
+			switch e := res.Error.(type) {
+			case string:
+				return errors.New(fmt.Sprintf("%s (%s)", e, resp.Status))
+			case map[string]interface{}:
+				if message, ok := e["message"].(string); ok {
+					return errors.New(fmt.Sprintf("%s (%s)", message, resp.Status))
+				}
+			}
+		}
+		return errors.New(fmt.Sprintf("The speech backend responded with %s", resp.Status))
+	}
+
+	if err := read(resp.Body); err != nil {
+		return errors.New(fmt.Sprintf("Couldn't read the response of the speech backend: %v", err))
+	}
+	return nil
+}
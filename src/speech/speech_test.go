@@ -0,0 +1,98 @@
+package speech
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAITranscriber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/audio/transcriptions", r.URL.Path)
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		require.Equal(t, "whisper-1", r.FormValue("model"))
+
+		file, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		require.Equal(t, "voice.oga", header.Filename)
+		audio, _ := io.ReadAll(file)
+		require.Equal(t, "audio", string(audio))
+
+		w.Write([]byte(`{"text": " Hello there. "}`))
+	}))
+	defer srv.Close()
+
+	transcriber, err := NewTranscriber(BACKEND_OPENAI, srv.URL+"/v1/", "key", "")
+	require.NoError(t, err)
+
+	text, err := transcriber.Transcribe(context.Background(), []byte("audio"), "voice.oga")
+	require.NoError(t, err)
+	require.Equal(t, "Hello there.", text)
+}
+
+func TestWhisperTranscriberError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/inference", r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "failed to read audio"}`))
+	}))
+	defer srv.Close()
+
+	transcriber, err := NewTranscriber(BACKEND_WHISPER, srv.URL, "", "")
+	require.NoError(t, err)
+
+	_, err = transcriber.Transcribe(context.Background(), []byte("audio"), "voice.oga")
+	require.EqualError(t, err, "failed to read audio (400 Bad Request)")
+}
+
+func TestNewTranscriber(t *testing.T) {
+	transcriber, err := NewTranscriber("", "", "", "")
+	require.NoError(t, err)
+	require.Nil(t, transcriber)
+
+	_, err = NewTranscriber(BACKEND_WHISPER, "", "", "")
+	require.Error(t, err)
+
+	_, err = NewTranscriber("nope", "", "", "")
+	require.Error(t, err)
+}
+
+func TestOpenAISynthesizer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/audio/speech", r.URL.Path)
+
+		var req map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, map[string]string{"model": "tts-1", "voice": "alloy", "input": "Hello", "response_format": "opus"}, req)
+
+		w.Write([]byte("OggS"))
+	}))
+	defer srv.Close()
+
+	synthesizer, err := NewSynthesizer(BACKEND_OPENAI, srv.URL, "key", "", "", "")
+	require.NoError(t, err)
+
+	audio, err := synthesizer.Synthesize(context.Background(), "Hello")
+	require.NoError(t, err)
+	require.Equal(t, "OggS", string(audio))
+}
+
+func TestCommandSynthesizer(t *testing.T) {
+	synthesizer, err := NewSynthesizer(BACKEND_COMMAND, "", "", "", "", "tr a-z A-Z")
+	require.NoError(t, err)
+
+	audio, err := synthesizer.Synthesize(context.Background(), "hello")
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", string(audio))
+
+	synthesizer, err = NewSynthesizer(BACKEND_COMMAND, "", "", "", "", "echo oops >&2; exit 1")
+	require.NoError(t, err)
+
+	_, err = synthesizer.Synthesize(context.Background(), "hello")
+	require.ErrorContains(t, err, "oops")
+}
@@ -0,0 +1,115 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// BACKEND_COMMAND runs a local program, which reads the text from its standard input and writes the audio to its standard output
+const BACKEND_COMMAND = "command"
+
+const DEFAULT_SPEECH_MODEL = "tts-1"
+const DEFAULT_VOICE = "alloy"
+
+// maxSpeechLength is the most characters the OpenAI speech endpoint accepts, longer texts are cut off
+const maxSpeechLength = 4096
+
+// Synthesizer turns text into speech
+type Synthesizer interface {
+	// Synthesize returns text read out loud, as OGG/Opus audio so Telegram can show it as a voice message
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+}
+
+// NewSynthesizer returns the synthesizer for backend, or nil if backend is empty. baseURL, apiKey, model and voice are
+// used by the OpenAI backend, falling back to its defaults when empty, and command is the shell command of the command backend.
+func NewSynthesizer(backend string, baseURL string, apiKey string, model string, voice string, command string) (Synthesizer, error) {
+	switch backend {
+	case "":
+		return nil, nil
+	case BACKEND_OPENAI:
+		if baseURL == "" {
+			baseURL = DEFAULT_OPENAI_URL
+		}
+		if model == "" {
+			model = DEFAULT_SPEECH_MODEL
+		}
+		if voice == "" {
+			voice = DEFAULT_VOICE
+		}
+		return &openAISynthesizer{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, model: model, voice: voice}, nil
+	case BACKEND_COMMAND:
+		if command == "" {
+			return nil, errors.New("The command backend needs a command to run")
+		}
+		return &commandSynthesizer{command: command}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown speech backend %s", backend))
+	}
+}
+
+type openAISynthesizer struct {
+	baseURL string
+	apiKey  string
+	model   string
+	voice   string
+}
+
+func (s *openAISynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	if utf8.RuneCountInString(text) > maxSpeechLength {
+		text = string([]rune(text)[:maxSpeechLength])
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"model":           s.model,
+		"voice":           s.voice,
+		"input":           text,
+		"response_format": "opus",
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't encode request: %v", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't create request: %v", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
+	}
+
+	var audio []byte
+	err = do(req, func(r io.Reader) error {
+		audio, err = io.ReadAll(r)
+		return err
+	})
+	return audio, err
+}
+
+type commandSynthesizer struct {
+	command string
+}
+
+func (s *commandSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.command)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Speech command failed: %v %s", err, strings.TrimSpace(stderr.String())))
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("Speech command didn't output any audio")
+	}
+	return stdout.Bytes(), nil
+}
@@ -1,100 +1,124 @@
 package sse
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
-	"strings"
 
-	"github.com/google/uuid"
 	"github.com/launchdarkly/eventsource"
+	"github.com/m1guelpf/chatgpt-telegram/src/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Event is a single server-sent event. Err is set (and the other fields are empty)
+// when the stream couldn't be decoded, and is always the last event sent.
+type Event struct {
+	ID   string
+	Type string
+	Data string
+	Err  error
+}
+
+// StatusError is returned when the server doesn't respond with a 200 status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to connect to SSE: %v", e.Status)
+}
+
 type Client struct {
 	URL          string
-	EventChannel chan string
+	EventChannel chan Event
 	Headers      map[string]string
 }
 
 func Init(url string) Client {
 	return Client{
 		URL:          url,
-		EventChannel: make(chan string),
+		EventChannel: make(chan Event),
 	}
 }
 
-func (c *Client) Connect(message string, conversationId string, parentMessageId string) error {
-	messages, err := json.Marshal([]string{message})
+// Connect POSTs the given body to the client URL and streams the received events into EventChannel.
+func (c *Client) Connect(ctx context.Context, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, body)
 	if err != nil {
-		return errors.New(fmt.Sprintf("failed to encode message: %v", err))
-	}
-
-	if parentMessageId == "" {
-		parentMessageId = uuid.NewString()
+		return errors.New(fmt.Sprintf("failed to create request: %v", err))
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	var conversationIdString string
-	if conversationId != "" {
-		conversationIdString = fmt.Sprintf(`, "conversation_id": "%s"`, conversationId)
-	}
+	return c.ConnectRequest(req)
+}
 
-	// if conversation id is empty, don't send it
-	body := fmt.Sprintf(`{
-        "action": "next",
-        "messages": [
-            {
-                "id": "%s",
-                "role": "user",
-                "content": {
-                    "content_type": "text",
-                    "parts": %s
-                }
-            }
-        ],
-        "model": "text-davinci-002-render",
-		"parent_message_id": "%s"%s
-    }`, uuid.NewString(), string(messages), parentMessageId, conversationIdString)
-
-	req, err := http.NewRequest("POST", c.URL, strings.NewReader(body))
-	if err != nil {
-		return errors.New(fmt.Sprintf("failed to create request: %v", err))
-	}
+// ConnectRequest performs an arbitrary request and streams the received events into EventChannel.
+// The client headers are added on top of the ones already set on the request.
+// EventChannel is closed once the stream ends or the request context is cancelled.
+func (c *Client) ConnectRequest(req *http.Request) (err error) {
+	_, span := tracing.StartSpan(req.Context(), "sse.connect", trace.WithAttributes(
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.String()),
+	))
+	defer func() { tracing.End(span, err) }()
 
 	for key, value := range c.Headers {
 		req.Header.Set(key, value)
 	}
 	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Content-Type", "application/json")
 
 	http := &http.Client{}
 	resp, err := http.Do(req)
 	if err != nil {
 		return errors.New(fmt.Sprintf("failed to connect to SSE: %v", err))
 	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 
 	if resp.StatusCode != 200 {
-		return errors.New(fmt.Sprintf("failed to connect to SSE: %v", resp.Status))
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: body}
 	}
 
 	decoder := eventsource.NewDecoder(resp.Body)
+	ctx := req.Context()
 
 	go func() {
 		defer resp.Body.Close()
 		defer close(c.EventChannel)
 
 		for {
+			var e Event
+
 			event, err := decoder.Decode()
+			if err == io.EOF {
+				return
+			}
 			if err != nil {
-				log.Println(errors.New(fmt.Sprintf("failed to decode event: %v", err)))
-				break
+				e.Err = errors.New(fmt.Sprintf("failed to decode event: %v", err))
+			} else {
+				// skip keep-alive events
+				if event.Data() == "" {
+					continue
+				}
+				e.ID, e.Type, e.Data = event.Id(), event.Event(), event.Data()
 			}
-			if event.Data() == "[DONE]" || event.Data() == "" {
-				break
+
+			select {
+			case c.EventChannel <- e:
+			case <-ctx.Done():
+				return
 			}
 
-			c.EventChannel <- event.Data()
+			if e.Err != nil {
+				return
+			}
 		}
 	}()
 
@@ -0,0 +1,99 @@
+package sse
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnect(t *testing.T) {
+	stream := strings.Join([]string{
+		": keep-alive comment",
+		"",
+		`data: {"message": "Hel"}`,
+		"",
+		"data:",
+		"",
+		"id: 2",
+		"event: delta",
+		`data: {"message": "Hello"}`,
+		"",
+		"data: first line",
+		"data: second line",
+		"",
+		"data: [DONE]",
+		"",
+		"",
+	}, "\n")
+
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	}))
+	defer server.Close()
+
+	client := Init(server.URL)
+	client.Headers = map[string]string{"Authorization": "Bearer token"}
+	require.NoError(t, client.Connect(context.Background(), strings.NewReader(`{"prompt": "Hi"}`)))
+
+	var events []Event
+	for event := range client.EventChannel {
+		events = append(events, event)
+	}
+
+	require.Equal(t, `{"prompt": "Hi"}`, string(body))
+	require.Equal(t, "application/json", header.Get("Content-Type"))
+	require.Equal(t, "text/event-stream", header.Get("Accept"))
+	require.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	// keep-alives are skipped, and the channel is closed at the end of the stream
+	require.Equal(t, []Event{
+		{Data: `{"message": "Hel"}`},
+		{ID: "2", Type: "delta", Data: `{"message": "Hello"}`},
+		{Data: "first line\nsecond line"},
+		{Data: "[DONE]"},
+	}, events)
+}
+
+func TestConnectCutShort(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: complete\n\ndata: cut off\n"))
+	}))
+	defer server.Close()
+
+	client := Init(server.URL)
+	require.NoError(t, client.Connect(context.Background(), strings.NewReader(`{}`)))
+
+	require.Equal(t, Event{Data: "complete"}, <-client.EventChannel)
+	require.Error(t, (<-client.EventChannel).Err)
+	_, open := <-client.EventChannel
+	require.False(t, open)
+}
+
+func TestConnectStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"detail": "Slow down"}`))
+	}))
+	defer server.Close()
+
+	client := Init(server.URL)
+	err := client.Connect(context.Background(), strings.NewReader(`{}`))
+
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusTooManyRequests, statusErr.StatusCode)
+	require.Equal(t, "5", statusErr.Header.Get("Retry-After"))
+	require.Equal(t, `{"detail": "Slow down"}`, string(statusErr.Body))
+}
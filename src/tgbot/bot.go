@@ -1,27 +1,31 @@
 package tgbot
 
 import (
-	"log"
-	"os"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/m1guelpf/chatgpt-telegram/src/chatgpt"
+	"github.com/m1guelpf/chatgpt-telegram/src/expirymap"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
 	"github.com/m1guelpf/chatgpt-telegram/src/markdown"
-	"github.com/m1guelpf/chatgpt-telegram/src/ratelimit"
+	"github.com/m1guelpf/chatgpt-telegram/src/metrics"
 )
 
 type Bot struct {
 	Username     string
+	ID           int64
 	api          *tgbotapi.BotAPI
-	editInterval time.Duration
+	fileEndpoint string
+	editInterval atomic.Int64
+	lastOutputs  *expirymap.ExpiryMap[int64, outputState]
 }
 
-func New(token string, editInterval time.Duration) (*Bot, error) {
+// New connects to Telegram, through apiEndpoint if it's not empty
+func New(token string, apiEndpoint string, editInterval time.Duration) (*Bot, error) {
 	var api *tgbotapi.BotAPI
 	var err error
-	apiEndpoint, exist := os.LookupEnv("TELEGRAM_API_ENDPOINT")
-	if exist && apiEndpoint != "" {
+	if apiEndpoint != "" {
 		api, err = tgbotapi.NewBotAPIWithAPIEndpoint(token, apiEndpoint)
 	} else {
 		api, err = tgbotapi.NewBotAPI(token)
@@ -30,11 +34,20 @@ func New(token string, editInterval time.Duration) (*Bot, error) {
 		return nil, err
 	}
 
-	return &Bot{
+	b := &Bot{
 		Username:     api.Self.UserName,
+		ID:           api.Self.ID,
 		api:          api,
-		editInterval: editInterval,
-	}, nil
+		fileEndpoint: fileEndpoint(apiEndpoint),
+		lastOutputs:  expirymap.New(expirymap.WithMaxSize[int64, outputState](maxLastOutputs)),
+	}
+	b.SetEditInterval(editInterval)
+	return b, nil
+}
+
+// SetEditInterval changes how long to wait between edits of live outputs, starting with the next one
+func (b *Bot) SetEditInterval(editInterval time.Duration) {
+	b.editInterval.Store(int64(editInterval))
 }
 
 func (b *Bot) GetUpdatesChan() tgbotapi.UpdatesChannel {
@@ -43,6 +56,12 @@ func (b *Bot) GetUpdatesChan() tgbotapi.UpdatesChannel {
 	return b.api.GetUpdatesChan(cfg)
 }
 
+// Ping checks that Telegram can be reached and the token is still valid
+func (b *Bot) Ping() error {
+	_, err := b.api.GetMe()
+	return err
+}
+
 func (b *Bot) Stop() {
 	b.api.StopReceivingUpdates()
 }
@@ -55,59 +74,91 @@ func (b *Bot) Send(chatID int64, replyTo int, text string) (tgbotapi.Message, er
 }
 
 func (b *Bot) SendEdit(chatID int64, messageID int, text string) error {
+	return b.sendEditWithKeyboard(chatID, messageID, text, nil)
+}
+
+func (b *Bot) sendEditWithKeyboard(chatID int64, messageID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
 	text = markdown.EnsureFormatting(text)
 	msg := tgbotapi.NewEditMessageText(chatID, messageID, text)
 	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = keyboard
 	if _, err := b.api.Send(msg); err != nil {
 		if err.Error() == "Bad Request: message is not modified: specified new message content and reply markup are exactly the same as a current content and reply markup of the message" {
 			return nil
 		}
+		metrics.ObserveEditFailure()
 		return err
 	}
 	return nil
 }
 
-func (b *Bot) SendTyping(chatID int64) {
-	if _, err := b.api.Request(tgbotapi.NewChatAction(chatID, "typing")); err != nil {
-		log.Printf("Couldn't send typing action: %v", err)
+// RemoveKeyboard removes the inline buttons attached to a message
+func (b *Bot) RemoveKeyboard(chatID int64, messageID int) {
+	msg := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, tgbotapi.NewInlineKeyboardMarkup())
+	if _, err := b.api.Request(msg); err != nil {
+		logger.With("chat_id", chatID, "message_id", messageID).Warnf("Couldn't remove message keyboard: %v", err)
 	}
 }
 
-func (b *Bot) SendAsLiveOutput(chatID int64, replyTo int, feed chan chatgpt.ChatResponse) {
-	debouncedType := ratelimit.Debounce(10*time.Second, func() { b.SendTyping(chatID) })
-	debouncedEdit := ratelimit.DebounceWithArgs(b.editInterval, func(text interface{}, messageId interface{}) {
-		if err := b.SendEdit(chatID, messageId.(int), text.(string)); err != nil {
-			log.Printf("Couldn't edit message: %v", err)
-		}
+func (b *Bot) DeleteMessage(chatID int64, messageID int) {
+	if _, err := b.api.Request(tgbotapi.NewDeleteMessage(chatID, messageID)); err != nil {
+		logger.With("chat_id", chatID, "message_id", messageID).Warnf("Couldn't delete message: %v", err)
+	}
+}
+
+// AnswerCallback acknowledges a button press, optionally showing text to the user
+func (b *Bot) AnswerCallback(queryID string, text string) {
+	if _, err := b.api.Request(tgbotapi.NewCallback(queryID, text)); err != nil {
+		logger.Warnf("Couldn't answer callback query: %v", err)
+	}
+}
+
+// maxInlineDescription is how much of the answer is previewed in inline results
+const maxInlineDescription = 200
+
+// AnswerInline answers an inline query with a single article, which sends text when picked. Results are personal,
+// so Telegram doesn't show them to other users who may not be allowed to use the bot.
+func (b *Bot) AnswerInline(queryID string, title string, text string, cacheTime time.Duration) error {
+	if utf8.RuneCountInString(text) > maxMessageLength {
+		text = string([]rune(text)[:maxMessageLength-1]) + "…"
+	}
+	description := []rune(text)
+	if len(description) > maxInlineDescription {
+		description = description[:maxInlineDescription]
+	}
+
+	article := tgbotapi.NewInlineQueryResultArticle("answer", title, text)
+	article.Description = string(description)
+	_, err := b.api.Request(tgbotapi.InlineConfig{
+		InlineQueryID: queryID,
+		Results:       []interface{}{article},
+		CacheTime:     int(cacheTime.Seconds()),
+		IsPersonal:    true,
+	})
+	return err
+}
+
+// AnswerInlineHint answers an inline query without results, showing hint as a button that opens the chat with the bot
+func (b *Bot) AnswerInlineHint(queryID string, hint string) error {
+	_, err := b.api.Request(tgbotapi.InlineConfig{
+		InlineQueryID:     queryID,
+		Results:           []interface{}{},
+		IsPersonal:        true,
+		SwitchPMText:      hint,
+		SwitchPMParameter: "inline",
 	})
+	return err
+}
 
-	var message tgbotapi.Message
-	var lastResp string
-
-pollResponse:
-	for {
-		debouncedType()
-
-		select {
-		case response, ok := <-feed:
-			if !ok {
-				break pollResponse
-			}
-
-			lastResp = response.Message
-
-			if message.MessageID == 0 {
-				var err error
-				if message, err = b.Send(chatID, replyTo, lastResp); err != nil {
-					log.Fatalf("Couldn't send message: %v", err)
-				}
-			} else {
-				debouncedEdit(lastResp, message.MessageID)
-			}
-		}
+func (b *Bot) SendTyping(chatID int64) {
+	if _, err := b.api.Request(tgbotapi.NewChatAction(chatID, "typing")); err != nil {
+		logger.With("chat_id", chatID).Debugf("Couldn't send typing action: %v", err)
 	}
+}
 
-	if err := b.SendEdit(chatID, message.MessageID, lastResp); err != nil {
-		log.Printf("Couldn't perform final edit on message: %v", err)
+// SendUploadingPhoto shows that the bot is sending a photo, while it's drawn
+func (b *Bot) SendUploadingPhoto(chatID int64) {
+	if _, err := b.api.Request(tgbotapi.NewChatAction(chatID, tgbotapi.ChatUploadPhoto)); err != nil {
+		logger.With("chat_id", chatID).Debugf("Couldn't send upload_photo action: %v", err)
 	}
 }
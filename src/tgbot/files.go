@@ -0,0 +1,78 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxDownloadSize is the largest file bots can download through the Bot API
+const maxDownloadSize = 20 << 20
+
+// downloadTimeout bounds downloading a file, including reading all of it
+const downloadTimeout = 2 * time.Minute
+
+var downloadClient = &http.Client{Timeout: downloadTimeout}
+
+// fileEndpoint returns where files are downloaded from, which is next to the API endpoint of custom Bot API servers
+func fileEndpoint(apiEndpoint string) string {
+	if apiEndpoint == "" || !strings.HasSuffix(apiEndpoint, "/bot%s/%s") {
+		return tgbotapi.FileEndpoint
+	}
+	return strings.TrimSuffix(apiEndpoint, "/bot%s/%s") + "/file/bot%s/%s"
+}
+
+// DownloadFile fetches a file sent to the bot, returning its contents and name
+func (b *Bot) DownloadFile(ctx context.Context, fileID string) ([]byte, string, error) {
+	file, err := b.api.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("Couldn't get file: %v", err))
+	}
+	if file.FileSize > maxDownloadSize {
+		return nil, "", errors.New(fmt.Sprintf("The file is too big, bots can only download files up to %d MB", maxDownloadSize>>20))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(b.fileEndpoint, b.api.Token, file.FilePath), nil)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("Couldn't create request: %v", err))
+	}
+
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("Couldn't download file: %v", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New(fmt.Sprintf("Couldn't download file: %s", resp.Status))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize))
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("Couldn't download file: %v", err))
+	}
+
+	return data, path.Base(file.FilePath), nil
+}
+
+// SendPhoto sends image as a photo, replying to replyTo
+func (b *Bot) SendPhoto(chatID int64, replyTo int, image []byte) error {
+	msg := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "image.png", Bytes: image})
+	msg.ReplyToMessageID = replyTo
+	_, err := b.api.Send(msg)
+	return err
+}
+
+// SendVoice sends OGG/Opus audio as a voice message, replying to replyTo
+func (b *Bot) SendVoice(chatID int64, replyTo int, audio []byte) error {
+	msg := tgbotapi.NewVoice(chatID, tgbotapi.FileBytes{Name: "answer.ogg", Bytes: audio})
+	msg.ReplyToMessageID = replyTo
+	_, err := b.api.Send(msg)
+	return err
+}
@@ -0,0 +1,26 @@
+package tgbot
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const continuePrefix = "continue:"
+
+func continueKeyboard(messageID string) *tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Continue", continuePrefix+messageID),
+		),
+	)
+	return &keyboard
+}
+
+// ParseContinueData returns the ChatGPT message ID a "Continue" button refers to
+func ParseContinueData(data string) (string, bool) {
+	if !strings.HasPrefix(data, continuePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(data, continuePrefix), true
+}
@@ -0,0 +1,273 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/m1guelpf/chatgpt-telegram/src/chatgpt"
+	"github.com/m1guelpf/chatgpt-telegram/src/logger"
+	"github.com/m1guelpf/chatgpt-telegram/src/ratelimit"
+	"github.com/m1guelpf/chatgpt-telegram/src/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxMessageLength is kept under Telegram's 4096 characters limit, to leave room for formatting fixes and status notes.
+// Lengths are measured in bytes, which is never less than what Telegram counts.
+const maxMessageLength = 4000
+
+// Answers can be continued (appending to their last message) for lastOutputExpiry, in up to maxLastOutputs chats
+const lastOutputExpiry = 24 * time.Hour
+const maxLastOutputs = 1000
+
+// outputState remembers the last message of an answer, so it can be continued later
+type outputState struct {
+	messageID    int
+	text         string
+	gptMessageID string
+}
+
+// liveOutput is an answer being streamed into a chain of messages, each one replying to the previous.
+type liveOutput struct {
+	chatID  int64
+	replyTo int
+	// statusID is the message showing retry progress, if any
+	statusID int
+	// messageID is the last message of the chain, showing text[offset:] (preceded by reopen)
+	messageID int
+	offset    int
+	reopen    string
+	// prefix is the text of the message being continued
+	prefix string
+	text   string
+}
+
+func (o *liveOutput) current() string {
+	return o.reopen + o.text[o.offset:]
+}
+
+// SendAsLiveOutput streams feed into a chain of messages replying to replyTo, returning the answer once it's complete.
+// Upstream errors are shown to the user and return an empty answer. If a message can't be sent,
+// the rest of the feed is discarded and the error is returned.
+func (b *Bot) SendAsLiveOutput(ctx context.Context, chatID int64, replyTo int, feed chan chatgpt.ChatResponse) (string, error) {
+	return b.sendLiveOutput(ctx, &liveOutput{chatID: chatID, replyTo: replyTo}, feed)
+}
+
+// ContinueLiveOutput appends feed to the last answer sent to chatID, starting a new chain that replies to replyTo if there's none.
+// The returned answer only contains the text that was appended.
+func (b *Bot) ContinueLiveOutput(ctx context.Context, chatID int64, replyTo int, feed chan chatgpt.ChatResponse) (string, error) {
+	out := &liveOutput{chatID: chatID, replyTo: replyTo}
+
+	if last, ok := b.lastOutputs.Get(chatID); ok {
+		out.messageID = last.messageID
+		out.prefix = last.text
+		out.text = last.text
+	}
+
+	return b.sendLiveOutput(ctx, out, feed)
+}
+
+func (b *Bot) sendLiveOutput(ctx context.Context, out *liveOutput, feed chan chatgpt.ChatResponse) (string, error) {
+	log := logger.With("chat_id", out.chatID)
+	debouncedType := ratelimit.Debounce(10*time.Second, func() { b.SendTyping(out.chatID) })
+	debouncedEdit := ratelimit.DebounceWithArgs(time.Duration(b.editInterval.Load()), func(text interface{}, messageId interface{}) {
+		_, span := tracing.StartSpan(ctx, "telegram.edit", messageAttributes(out.chatID, messageId.(int)))
+		err := b.SendEdit(out.chatID, messageId.(int), text.(string))
+		tracing.End(span, err)
+		if err != nil {
+			log.With("message_id", messageId).Warnf("Couldn't edit message: %v", err)
+		}
+	})
+
+	var final chatgpt.ChatResponse
+
+pollResponse:
+	for {
+		debouncedType()
+
+		select {
+		case response, ok := <-feed:
+			if !ok {
+				break pollResponse
+			}
+
+			switch response.Type {
+			case chatgpt.ResponseError:
+				b.sendError(out, response.Err)
+				return "", nil
+			case chatgpt.ResponseRetry:
+				b.sendRetryStatus(out, response)
+				continue
+			case chatgpt.ResponseNotice:
+				if _, err := b.Send(out.chatID, out.replyTo, response.Message); err != nil {
+					logger.With("chat_id", out.chatID).Warnf("Couldn't send notice: %v", err)
+				}
+				continue
+			case chatgpt.ResponseFinal:
+				final = response
+			}
+
+			if out.statusID != 0 {
+				// the answer takes the place of the retry status, unless we're appending to an existing message
+				if out.messageID == 0 {
+					out.messageID = out.statusID
+				} else {
+					b.DeleteMessage(out.chatID, out.statusID)
+				}
+				out.statusID = 0
+			}
+
+			out.text = out.prefix + response.Message
+			if err := b.render(out, func(text string, messageID int) { debouncedEdit(text, messageID) }); err != nil {
+				// keep the sender from blocking on a feed nobody reads anymore
+				go func() {
+					for range feed {
+					}
+				}()
+				return "", err
+			}
+		}
+	}
+
+	if out.messageID == 0 {
+		return "", nil
+	}
+
+	text := out.current()
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	switch {
+	case final.Interrupted:
+		text += "\n\n⚠️ _Response interrupted_"
+		keyboard = continueKeyboard(final.Metadata.MessageID)
+	case final.Truncated():
+		text += "\n\n✂️ _Answer cut off, send /continue for the rest_"
+		keyboard = continueKeyboard(final.Metadata.MessageID)
+	}
+
+	_, span := tracing.StartSpan(ctx, "telegram.final_edit", messageAttributes(out.chatID, out.messageID))
+	err := b.sendEditWithKeyboard(out.chatID, out.messageID, text, keyboard)
+	tracing.End(span, err)
+	if err != nil {
+		log.With("message_id", out.messageID).Errorf("Couldn't perform final edit on message: %v", err)
+	}
+
+	b.lastOutputs.Set(out.chatID, outputState{
+		messageID:    out.messageID,
+		text:         out.current(),
+		gptMessageID: final.Metadata.MessageID,
+	}, lastOutputExpiry)
+	return final.Message, nil
+}
+
+// render brings the message chain up to date with out.text. Messages that overflow are completed right away
+// and a new one is started, while the last message is updated through edit.
+func (b *Bot) render(out *liveOutput, edit func(text string, messageID int)) error {
+	for len(out.current()) > maxMessageLength {
+		current := out.current()
+		split := splitIndex(current, maxMessageLength)
+		head := current[:split]
+
+		if out.messageID == 0 {
+			message, err := b.Send(out.chatID, out.replyTo, head)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Couldn't send message: %v", err))
+			}
+			out.messageID = message.MessageID
+		} else if err := b.SendEdit(out.chatID, out.messageID, head); err != nil {
+			logger.With("chat_id", out.chatID, "message_id", out.messageID).Errorf("Couldn't perform final edit on message: %v", err)
+		}
+
+		out.offset += split - len(out.reopen)
+		out.reopen = ""
+		if strings.Count(head, "```")%2 == 1 {
+			// the code block was closed at the end of the previous message, so open it again
+			out.reopen = "```\n"
+		}
+		out.replyTo = out.messageID
+		out.messageID = 0
+	}
+
+	if out.messageID == 0 {
+		message, err := b.Send(out.chatID, out.replyTo, out.current())
+		if err != nil {
+			return errors.New(fmt.Sprintf("Couldn't send message: %v", err))
+		}
+		out.messageID = message.MessageID
+		return nil
+	}
+
+	edit(out.current(), out.messageID)
+	return nil
+}
+
+func messageAttributes(chatID int64, messageID int) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.Int64("telegram.chat_id", chatID), attribute.Int("telegram.message_id", messageID))
+}
+
+// splitIndex finds where to split text so the first part is at most max bytes long, preferring line breaks.
+func splitIndex(text string, max int) int {
+	if i := strings.LastIndex(text[:max], "\n"); i > max/2 {
+		return i + 1
+	}
+
+	i := max
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	return i
+}
+
+func (b *Bot) sendRetryStatus(out *liveOutput, response chatgpt.ChatResponse) {
+	status := fmt.Sprintf("Upstream busy, retrying %d/%d…", response.Attempt, response.MaxAttempts)
+	log := logger.With("chat_id", out.chatID)
+
+	if out.statusID != 0 {
+		if err := b.SendEdit(out.chatID, out.statusID, status); err != nil {
+			log.Warnf("Couldn't edit retry status: %v", err)
+		}
+		return
+	}
+
+	replyTo := out.replyTo
+	if out.messageID != 0 {
+		replyTo = out.messageID
+	}
+
+	message, err := b.Send(out.chatID, replyTo, status)
+	if err != nil {
+		log.Warnf("Couldn't send retry status: %v", err)
+		return
+	}
+	out.statusID = message.MessageID
+}
+
+// sendError reports an upstream error to the user. A retry status is replaced by the error, otherwise
+// the answer so far is brought up to date and the error is sent as a reply to it.
+func (b *Bot) sendError(out *liveOutput, err error) {
+	text := fmt.Sprintf("Error: %v", err)
+	log := logger.With("chat_id", out.chatID)
+
+	if out.statusID != 0 {
+		if err := b.SendEdit(out.chatID, out.statusID, text); err != nil {
+			log.Errorf("Couldn't send error message: %v", err)
+		}
+		return
+	}
+
+	replyTo := out.replyTo
+	if out.messageID != 0 {
+		if err := b.SendEdit(out.chatID, out.messageID, out.current()); err != nil {
+			log.Errorf("Couldn't perform final edit on message: %v", err)
+		}
+		replyTo = out.messageID
+	}
+
+	if _, err := b.Send(out.chatID, replyTo, text); err != nil {
+		log.Errorf("Couldn't send error message: %v", err)
+	}
+}
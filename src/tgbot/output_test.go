@@ -0,0 +1,216 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m1guelpf/chatgpt-telegram/src/chatgpt"
+	"github.com/stretchr/testify/require"
+)
+
+// telegramCall is a message sent (messageID being the one it got) or edited through the fake Telegram API
+type telegramCall struct {
+	method    string
+	messageID int
+	replyTo   int
+	text      string
+}
+
+// fakeTelegram serves the Telegram API methods used to send answers, recording the messages sent and edited
+type fakeTelegram struct {
+	mu     sync.Mutex
+	calls  []telegramCall
+	lastID int
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := telegramCall{method: method, text: r.Form.Get("text")}
+	call.replyTo, _ = strconv.Atoi(r.Form.Get("reply_to_message_id"))
+	switch method {
+	case "getMe":
+		fmt.Fprint(w, `{"ok": true, "result": {"id": 1, "is_bot": true, "username": "test_bot"}}`)
+		return
+	case "sendMessage":
+		f.lastID++
+		call.messageID = f.lastID
+	case "editMessageText":
+		call.messageID, _ = strconv.Atoi(r.Form.Get("message_id"))
+	default:
+		fmt.Fprint(w, `{"ok": true, "result": true}`)
+		return
+	}
+
+	f.calls = append(f.calls, call)
+	fmt.Fprintf(w, `{"ok": true, "result": {"message_id": %d, "date": 0, "chat": {"id": 1}}}`, call.messageID)
+}
+
+func newTestBot(t *testing.T) (*Bot, *fakeTelegram) {
+	telegram := &fakeTelegram{}
+	server := httptest.NewServer(telegram)
+	t.Cleanup(server.Close)
+
+	b, err := New("token", server.URL+"/bot%s/%s", time.Second)
+	require.NoError(t, err)
+	return b, telegram
+}
+
+func TestSplitIndex(t *testing.T) {
+	for label, test := range map[string]struct {
+		text string
+		max  int
+		want int
+	}{
+		"after the last line break": {
+			text: "first line\nsecond line\nthird line",
+			max:  26,
+			want: 23,
+		},
+		"line break too early": {
+			text: "a\nlong line that goes on",
+			max:  10,
+			want: 10,
+		},
+		"before a code fence": {
+			text: "Here's the code:\n```go\nfmt.Println()\n```",
+			max:  21,
+			want: 17,
+		},
+		"rune boundary": {
+			text: "aaaé",
+			max:  4,
+			want: 3,
+		},
+		"multi-byte runes": {
+			text: "日本語のテキスト",
+			max:  7,
+			want: 6,
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			require.Equal(t, test.want, splitIndex(test.text, test.max))
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	long := strings.Repeat("a", maxMessageLength-10) + "\n" + strings.Repeat("b", 20)
+	codeBlock := "```go\n" + strings.Repeat("line\n", 900) + "```"
+	codeHead := "```go\n" + strings.Repeat("line\n", 798)
+
+	type edit struct {
+		text      string
+		messageID int
+	}
+
+	for label, test := range map[string]struct {
+		out       liveOutput
+		wantCalls []telegramCall
+		wantEdits []edit
+		// wantMessageID and wantReplyTo are the last message of the chain and the one it replies to
+		wantMessageID int
+		wantReplyTo   int
+	}{
+		"first message": {
+			out:           liveOutput{replyTo: 100, text: "Hello"},
+			wantCalls:     []telegramCall{{method: "sendMessage", messageID: 1, replyTo: 100, text: "Hello"}},
+			wantMessageID: 1,
+			wantReplyTo:   100,
+		},
+		"updates are edits": {
+			out:           liveOutput{replyTo: 100, messageID: 50, text: "Hello there"},
+			wantEdits:     []edit{{text: "Hello there", messageID: 50}},
+			wantMessageID: 50,
+			wantReplyTo:   100,
+		},
+		"overflow starts a message replying to the previous one": {
+			out: liveOutput{replyTo: 100, text: long},
+			wantCalls: []telegramCall{
+				{method: "sendMessage", messageID: 1, replyTo: 100, text: strings.Repeat("a", maxMessageLength-10) + "\n"},
+				{method: "sendMessage", messageID: 2, replyTo: 1, text: strings.Repeat("b", 20)},
+			},
+			wantMessageID: 2,
+			wantReplyTo:   1,
+		},
+		"edit moving across messages": {
+			out: liveOutput{replyTo: 100, messageID: 50, text: long},
+			wantCalls: []telegramCall{
+				{method: "editMessageText", messageID: 50, text: strings.Repeat("a", maxMessageLength-10) + "\n"},
+				{method: "sendMessage", messageID: 1, replyTo: 50, text: strings.Repeat("b", 20)},
+			},
+			wantMessageID: 1,
+			wantReplyTo:   50,
+		},
+		"split on a rune boundary": {
+			out: liveOutput{replyTo: 100, text: strings.Repeat("a", maxMessageLength-1) + "éb"},
+			wantCalls: []telegramCall{
+				{method: "sendMessage", messageID: 1, replyTo: 100, text: strings.Repeat("a", maxMessageLength-1)},
+				{method: "sendMessage", messageID: 2, replyTo: 1, text: "éb"},
+			},
+			wantMessageID: 2,
+			wantReplyTo:   1,
+		},
+		"code block reopened in the next message": {
+			out: liveOutput{replyTo: 100, text: codeBlock},
+			wantCalls: []telegramCall{
+				{method: "sendMessage", messageID: 1, replyTo: 100, text: codeHead + "```"},
+				{method: "sendMessage", messageID: 2, replyTo: 1, text: "```\n" + codeBlock[len(codeHead):]},
+			},
+			wantMessageID: 2,
+			wantReplyTo:   1,
+		},
+	} {
+		t.Run(label, func(t *testing.T) {
+			b, telegram := newTestBot(t)
+			out := test.out
+			out.chatID = 1
+
+			var edits []edit
+			require.NoError(t, b.render(&out, func(text string, messageID int) {
+				edits = append(edits, edit{text, messageID})
+			}))
+
+			require.Equal(t, test.wantCalls, telegram.calls)
+			require.Equal(t, test.wantEdits, edits)
+			require.Equal(t, test.wantMessageID, out.messageID)
+			require.Equal(t, test.wantReplyTo, out.replyTo)
+		})
+	}
+}
+
+func TestContinueLiveOutput(t *testing.T) {
+	b, telegram := newTestBot(t)
+	previous := strings.Repeat("a", maxMessageLength-10)
+	b.lastOutputs.Set(1, outputState{messageID: 50, text: previous}, time.Minute)
+
+	feed := make(chan chatgpt.ChatResponse, 1)
+	feed <- chatgpt.ChatResponse{Type: chatgpt.ResponseFinal, Message: "\n" + strings.Repeat("b", 20)}
+	close(feed)
+
+	answer, err := b.ContinueLiveOutput(context.Background(), 1, 100, feed)
+	require.NoError(t, err)
+	require.Equal(t, "\n"+strings.Repeat("b", 20), answer)
+
+	// the answer being continued is completed, and the rest goes into a new message replying to it
+	require.Equal(t, []telegramCall{
+		{method: "editMessageText", messageID: 50, text: previous + "\n"},
+		{method: "sendMessage", messageID: 1, replyTo: 50, text: strings.Repeat("b", 20)},
+		{method: "editMessageText", messageID: 1, text: strings.Repeat("b", 20)},
+	}, telegram.calls)
+
+	last, ok := b.lastOutputs.Get(1)
+	require.True(t, ok)
+	require.Equal(t, outputState{messageID: 1, text: strings.Repeat("b", 20)}, last)
+}